@@ -0,0 +1,471 @@
+package mdb
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+// Limits of a single batch sent by Bulk, which keep batches within what
+// every supported server accepts.
+const (
+	bulkMaxOps   = 1000
+	bulkMaxBytes = 16*1024*1024 - 16*1024
+)
+
+// Bulk queues insert, update and remove operations, and sends them when Run
+// is called, split into batches the server accepts. Batches failing with a
+// network error are sent again after refreshing the session, as with the
+// other operations of a Collection, as far as that is safe: inserts of
+// documents with an _id resume with the documents missing on the server,
+// and updates and removes are sent again only if applying them twice has
+// the effect of applying them once, such as $set updates of a given _id and
+// RemoveAll. Other batches report the network error for each of their
+// operations, whose outcome is unknown.
+//
+// For example:
+//
+//     bulk := c.Bulk().Unordered()
+//     bulk.Insert(doc1, doc2)
+//     bulk.Update(bson.M{"_id": 1}, bson.M{"$inc": bson.M{"n": 1}})
+//     result, err := bulk.Run()
+//
+// Operations are sent as queued: hooks, timestamps, soft deletion and struct
+// validation do not apply to them.
+type Bulk struct {
+	col      *Collection
+	ops      []bulkOp
+	ordered  bool
+	maxOps   int
+	maxBytes int
+}
+
+type bulkKind int
+
+const (
+	bulkInsert bulkKind = iota
+	bulkUpdate
+	bulkRemove
+)
+
+// bulkOp is a queued operation. Inserts keep their document in doc, while
+// updates and removes keep their selector.
+type bulkOp struct {
+	kind   bulkKind
+	doc    interface{}
+	update interface{}
+	multi  bool
+	upsert bool
+	size   int
+	err    error
+}
+
+// BulkResult holds the results of Bulk.Run.
+type BulkResult struct {
+	Inserted int // Documents inserted, or found on the server when resuming inserts.
+	Matched  int // Documents matched by updates, or removed.
+	Modified int // Documents modified by updates, or removed.
+	Batches  int // Batches sent to the server, retries excluded.
+}
+
+// BulkError holds the errors of the operations which failed in Bulk.Run.
+type BulkError struct {
+	ecases []mgo.BulkErrorCase
+}
+
+func (e *BulkError) Error() string {
+	if len(e.ecases) == 1 {
+		return e.ecases[0].Err.Error()
+	}
+	var buf bytes.Buffer
+	buf.WriteString("multiple errors in bulk operation:\n")
+	seen := map[string]bool{}
+	for _, ecase := range e.ecases {
+		msg := ecase.Err.Error()
+		if !seen[msg] {
+			seen[msg] = true
+			buf.WriteString("  - ")
+			buf.WriteString(msg)
+			buf.WriteByte('\n')
+		}
+	}
+	return buf.String()
+}
+
+// Cases returns the error of each failed operation, with the position in
+// which it was queued as Index, sorted by Index.
+func (e *BulkError) Cases() []mgo.BulkErrorCase {
+	return e.ecases
+}
+
+// Bulk returns a value to prepare the execution of a bulk operation.
+func (c *Collection) Bulk() *Bulk {
+	return &Bulk{col: c, ordered: true, maxOps: bulkMaxOps, maxBytes: bulkMaxBytes}
+}
+
+// Unordered puts the bulk operation in unordered mode.
+//
+// In unordered mode the individual operations may be sent out of order,
+// and operations following a failed one are still run. In the default
+// ordered mode, Run stops at the first failed operation.
+func (b *Bulk) Unordered() *Bulk {
+	b.ordered = false
+	return b
+}
+
+// BatchSize sets the maximum number of operations sent in a single batch,
+// 1000 by default.
+func (b *Bulk) BatchSize(n int) *Bulk {
+	if n > 0 {
+		b.maxOps = n
+	}
+	return b
+}
+
+// Insert queues up the provided documents for insertion.
+func (b *Bulk) Insert(docs ...interface{}) {
+	for _, doc := range docs {
+		b.add(bulkOp{kind: bulkInsert, doc: doc})
+	}
+}
+
+// Update queues up the provided pairs of updating instructions.
+// The first element of each pair selects which documents must be
+// updated, and the second element defines how to update it.
+// Each pair matches exactly one document for updating at most.
+func (b *Bulk) Update(pairs ...interface{}) {
+	b.addPairs(pairs, false, false)
+}
+
+// UpdateAll queues up the provided pairs of updating instructions.
+// The first element of each pair selects which documents must be
+// updated, and the second element defines how to update it.
+// Each pair updates all documents matching the selector.
+func (b *Bulk) UpdateAll(pairs ...interface{}) {
+	b.addPairs(pairs, true, false)
+}
+
+// Upsert queues up the provided pairs of upserting instructions.
+// The first element of each pair selects which documents must be
+// updated, and the second element defines how to update it.
+// Each pair matches exactly one document for updating at most.
+func (b *Bulk) Upsert(pairs ...interface{}) {
+	b.addPairs(pairs, false, true)
+}
+
+// Remove queues up the provided selectors for removing matching documents.
+// Each selector will remove only a single matching document.
+func (b *Bulk) Remove(selectors ...interface{}) {
+	for _, selector := range selectors {
+		b.add(bulkOp{kind: bulkRemove, doc: selector})
+	}
+}
+
+// RemoveAll queues up the provided selectors for removing all matching
+// documents. Each selector will remove all matching documents.
+func (b *Bulk) RemoveAll(selectors ...interface{}) {
+	for _, selector := range selectors {
+		b.add(bulkOp{kind: bulkRemove, doc: selector, multi: true})
+	}
+}
+
+func (b *Bulk) addPairs(pairs []interface{}, multi, upsert bool) {
+	if len(pairs)%2 != 0 {
+		panic("Bulk.Update requires an even number of parameters")
+	}
+	for i := 0; i < len(pairs); i += 2 {
+		selector := pairs[i]
+		if selector == nil {
+			selector = bson.D{}
+		}
+		b.add(bulkOp{kind: bulkUpdate, doc: selector, update: pairs[i+1], multi: multi, upsert: upsert})
+	}
+}
+
+// add queues an operation, measuring its size so that batches can be kept
+// under the size limit.
+func (b *Bulk) add(op bulkOp) {
+	parts := []interface{}{op.doc}
+	if op.kind == bulkUpdate {
+		var arrayFilters []interface{}
+		op.update, arrayFilters, op.err = splitUpdate(op.update)
+		if op.err == nil && arrayFilters != nil {
+			op.err = errBulkArrayFilters
+		}
+		parts = append(parts, op.update)
+	}
+	for _, part := range parts {
+		if op.err != nil {
+			break
+		}
+		data, err := bson.Marshal(part)
+		op.size += len(data)
+		op.err = err
+	}
+	b.ops = append(b.ops, op)
+}
+
+var errBulkArrayFilters = errors.New("mdb: array filters are not supported by Bulk")
+
+// Run sends the queued operations, in batches of consecutive operations of
+// the same kind. The result counts the effect of the batches which
+// succeeded. If any operation failed, the error is a *BulkError holding
+// the position of each failed operation.
+//
+// The queue is emptied, so the Bulk may be reused for further operations.
+func (b *Bulk) Run() (*BulkResult, error) {
+	ops := b.ops
+	b.ops = nil
+	result := &BulkResult{}
+	berr := &BulkError{}
+	for start := 0; start < len(ops); {
+		end := b.batchEnd(ops, start)
+		if ops[start].err != nil {
+			end = start + 1
+			berr.ecases = append(berr.ecases, mgo.BulkErrorCase{Index: start, Err: ops[start].err})
+		} else {
+			result.Batches++
+			cases := b.runBatch(ops[start:end], result)
+			for _, ecase := range cases {
+				if ecase.Index >= 0 {
+					ecase.Index += start
+				}
+				berr.ecases = append(berr.ecases, ecase)
+			}
+		}
+		if b.ordered && len(berr.ecases) > 0 {
+			break
+		}
+		start = end
+	}
+	if len(berr.ecases) > 0 {
+		sort.SliceStable(berr.ecases, func(i, j int) bool { return berr.ecases[i].Index < berr.ecases[j].Index })
+		return result, berr
+	}
+	return result, nil
+}
+
+// batchEnd returns the end of the batch starting at ops[start].
+func (b *Bulk) batchEnd(ops []bulkOp, start int) int {
+	size := 0
+	for i := start; i < len(ops); i++ {
+		op := ops[i]
+		if op.kind != ops[start].kind || op.err != nil || i-start == b.maxOps {
+			return i
+		}
+		size += op.size
+		if size > b.maxBytes && i > start {
+			return i
+		}
+	}
+	return len(ops)
+}
+
+// runBatch sends a batch, adding its effect to result, and returns the
+// errors of its failed operations.
+//
+// A batch interrupted by a network error may have been partly applied, so
+// it is only sent again when that is safe: inserts resume with the
+// documents not found on the server, while updates and removes are sent
+// again only when repeating them changes nothing (see bulkOp.repeatable).
+// The documents found are counted as inserted, as they can't be told from
+// documents which were already there.
+func (b *Bulk) runBatch(ops []bulkOp, result *BulkResult) []mgo.BulkErrorCase {
+	pending := make([]int, len(ops))
+	for i := range pending {
+		pending[i] = i
+	}
+	res, err := b.send(ops, pending)
+	for i := 1; i < b.col.Database.MaxConnectRetries && isBulkNetworkError(err); i++ {
+		b.col.Database.refresh()
+		left, ok := b.unapplied(ops, pending)
+		if !ok {
+			break
+		}
+		pending = left
+		if len(pending) == 0 {
+			res, err = &mgo.BulkResult{}, nil
+			break
+		}
+		res, err = b.send(ops, pending)
+	}
+	// Inserts found on the server after a network error.
+	applied := len(ops) - len(pending)
+	if ops[0].kind == bulkInsert {
+		result.Inserted += applied
+	}
+	if err == nil {
+		if ops[0].kind == bulkInsert {
+			result.Inserted += len(pending)
+		}
+		result.Matched += res.Matched
+		result.Modified += res.Modified
+		return nil
+	}
+	if e, ok := err.(*mgo.BulkError); ok {
+		cases := append([]mgo.BulkErrorCase(nil), e.Cases()...)
+		if ops[0].kind == bulkInsert && !b.ordered {
+			result.Inserted += len(pending) - len(cases)
+		} else if ops[0].kind == bulkInsert && !isBulkNetworkError(err) {
+			result.Inserted += cases[0].Index
+		}
+		for i := range cases {
+			if cases[i].Index >= 0 {
+				cases[i].Index = pending[cases[i].Index]
+			}
+		}
+		return cases
+	}
+	cases := make([]mgo.BulkErrorCase, len(pending))
+	for i, op := range pending {
+		cases[i] = mgo.BulkErrorCase{Index: op, Err: err}
+	}
+	return cases
+}
+
+// send sends the operations of ops at the indexes in pending.
+func (b *Bulk) send(ops []bulkOp, pending []int) (*mgo.BulkResult, error) {
+	if err := b.col.Database.fault(b.col.Name, OpBulk); err != nil {
+		return nil, err
+	}
+	batch := make([]bulkOp, len(pending))
+	for i, op := range pending {
+		batch[i] = ops[op]
+	}
+	return b.mgoBulk(batch).Run()
+}
+
+// unapplied returns which of the pending operations of a batch interrupted
+// by a network error must be sent again, and whether that can be done
+// safely. Inserts are looked up by _id, so documents without one are never
+// sent again.
+func (b *Bulk) unapplied(ops []bulkOp, pending []int) ([]int, bool) {
+	if ops[0].kind != bulkInsert {
+		for _, op := range pending {
+			if !ops[op].repeatable() {
+				return nil, false
+			}
+		}
+		return pending, true
+	}
+	ids := make([]interface{}, len(pending))
+	for i, op := range pending {
+		d, err := toDoc(ops[op].doc)
+		if ids[i] = docValue(d, "_id"); err != nil || ids[i] == nil {
+			return nil, false
+		}
+	}
+	var found []struct {
+		Id interface{} `bson:"_id"`
+	}
+	query := bson.D{{Name: "_id", Value: bson.D{{Name: "$in", Value: ids}}}}
+	if err := b.col.col.Find(query).Select(bson.D{{Name: "_id", Value: 1}}).All(&found); err != nil {
+		return nil, false
+	}
+	stored := make(map[interface{}]bool, len(found))
+	for _, doc := range found {
+		stored[refKey(doc.Id)] = true
+	}
+	var left []int
+	for i, op := range pending {
+		if !stored[refKey(ids[i])] {
+			left = append(left, op)
+		} else if left != nil && b.ordered {
+			// An ordered batch stops at its first failure, so documents
+			// after a missing one were already there: send them again to
+			// report them as duplicates.
+			left = append(left, op)
+		}
+	}
+	return left, true
+}
+
+// repeatableUpdates are the update operators whose effect is the same when
+// applied twice.
+var repeatableUpdates = map[string]bool{
+	"$set":         true,
+	"$unset":       true,
+	"$setOnInsert": true,
+	"$addToSet":    true,
+	"$min":         true,
+	"$max":         true,
+}
+
+// repeatable reports whether sending op again after it was applied changes
+// nothing: removals of all matching documents, and replacements or updates
+// using only repeatableUpdates, provided they apply to every matching
+// document or to the document with a given _id. Once applied, a single
+// update could match another document, and an upsert insert another one.
+func (op bulkOp) repeatable() bool {
+	switch op.kind {
+	case bulkRemove:
+		return op.multi
+	case bulkUpdate:
+		if !(op.multi && !op.upsert) && !selectsID(op.doc) {
+			return false
+		}
+		d, err := toDoc(op.update)
+		if err != nil {
+			return false
+		}
+		for _, e := range d {
+			if isOperatorDoc(d) && !repeatableUpdates[e.Name] {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// selectsID reports whether selector matches a single _id value.
+func selectsID(selector interface{}) bool {
+	d, err := toDoc(selector)
+	if err != nil {
+		return false
+	}
+	id := docValue(d, "_id")
+	if id == nil {
+		return false
+	}
+	// Values other than documents, such as numbers, don't marshal.
+	cond, err := toDoc(id)
+	return err != nil || !isOperatorDoc(cond)
+}
+
+func (b *Bulk) mgoBulk(ops []bulkOp) *mgo.Bulk {
+	bulk := b.col.col.Bulk()
+	if !b.ordered {
+		bulk.Unordered()
+	}
+	for _, op := range ops {
+		switch {
+		case op.kind == bulkInsert:
+			bulk.Insert(op.doc)
+		case op.kind == bulkRemove && op.multi:
+			bulk.RemoveAll(op.doc)
+		case op.kind == bulkRemove:
+			bulk.Remove(op.doc)
+		case op.upsert:
+			bulk.Upsert(op.doc, op.update)
+		case op.multi:
+			bulk.UpdateAll(op.doc, op.update)
+		default:
+			bulk.Update(op.doc, op.update)
+		}
+	}
+	return bulk
+}
+
+// isBulkNetworkError reports whether a batch failed because the connection
+// broke, in which case mgo reports the network error for every operation.
+func isBulkNetworkError(err error) bool {
+	if e, ok := err.(*mgo.BulkError); ok {
+		cases := e.Cases()
+		return len(cases) > 0 && isNetworkError(cases[0].Err)
+	}
+	return isNetworkError(err)
+}
@@ -0,0 +1,169 @@
+package mdb
+
+import (
+	"testing"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+func TestBulkBatches(t *testing.T) {
+	srv, db := dialTest(t)
+	c := db.C("people")
+
+	bulk := c.Bulk().BatchSize(3)
+	for i := 0; i < 7; i++ {
+		bulk.Insert(bson.M{"_id": i, "n": i})
+	}
+	bulk.UpdateAll(bson.M{"n": bson.M{"$gte": 5}}, Inc("n", 10))
+	bulk.Upsert(bson.M{"_id": 7}, Set("n", 7))
+	bulk.Remove(bson.M{"_id": 0}, bson.M{"_id": 1})
+	result, err := bulk.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Inserted != 7 || result.Matched != 5 || result.Batches != 5 {
+		t.Fatalf("result: %+v", result)
+	}
+	if n := srv.Received("insert"); n != 3 {
+		t.Fatalf("insert received %d times, want 3", n)
+	}
+	if n, _ := c.Count(); n != 6 {
+		t.Fatalf("%d documents, want 6", n)
+	}
+	if n, _ := c.Find(bson.M{"n": bson.M{"$gt": 10}}).Count(); n != 2 {
+		t.Fatalf("%d documents updated, want 2", n)
+	}
+	if result, err := bulk.Run(); err != nil || result.Batches != 0 {
+		t.Fatalf("second run: %+v %v", result, err)
+	}
+}
+
+func TestBulkErrors(t *testing.T) {
+	srv, db := dialTest(t)
+	c := db.C("people")
+
+	bulk := c.Bulk().BatchSize(2)
+	bulk.Insert(bson.M{"_id": 1}, bson.M{"_id": 2}, bson.M{"_id": 1}, bson.M{"_id": 3}, bson.M{"_id": 4})
+	result, err := bulk.Run()
+	berr, ok := err.(*BulkError)
+	if !ok || len(berr.Cases()) != 1 || berr.Cases()[0].Index != 2 {
+		t.Fatalf("ordered error: %#v", err)
+	}
+	if result.Inserted != 2 || result.Batches != 2 {
+		t.Fatalf("ordered result: %+v", result)
+	}
+
+	bulk = c.Bulk().Unordered().BatchSize(2)
+	bulk.Insert(bson.M{"_id": 1}, bson.M{"_id": 5}, bson.M{"_id": 6}, bson.M{"_id": 2})
+	bulk.Update(bson.M{"_id": 5}, Set("a", 1).Unset("a"))
+	bulk.Insert(bson.M{"_id": 7})
+	result, err = bulk.Run()
+	berr, ok = err.(*BulkError)
+	if !ok || len(berr.Cases()) != 3 {
+		t.Fatalf("unordered error: %#v", err)
+	}
+	for i, index := range []int{0, 3, 4} {
+		if got := berr.Cases()[i].Index; got != index {
+			t.Errorf("error %d at index %d, want %d", i, got, index)
+		}
+	}
+	if result.Inserted != 3 {
+		t.Fatalf("unordered result: %+v", result)
+	}
+
+	faults := NewFaultInjector()
+	faults.FailNext("people", OpBulk, 1, ErrFaultEOF)
+	db.SetFaultInjector(faults)
+	srv.DropNext("update", 1)
+	bulk = c.Bulk()
+	bulk.Insert(bson.M{"_id": 8})
+	bulk.Update(bson.M{"_id": 8}, Set("a", 1))
+	if result, err := bulk.Run(); err != nil || result.Inserted != 1 || result.Matched != 1 {
+		t.Fatalf("retried run: %+v %v", result, err)
+	}
+	if n := srv.Received("update"); n != 2 {
+		t.Fatalf("update received %d times, want 2", n)
+	}
+}
+
+func TestBulkPartlyApplied(t *testing.T) {
+	srv, db := dialTest(t)
+	c := db.C("people")
+
+	srv.DropAfterNext("insert", 1)
+	bulk := c.Bulk()
+	bulk.Insert(bson.M{"_id": 1, "n": 0}, bson.M{"_id": 2, "n": 0})
+	if result, err := bulk.Run(); err != nil || result.Inserted != 2 {
+		t.Fatalf("resumed insert: %+v %v", result, err)
+	}
+	if n := srv.Received("insert"); n != 1 {
+		t.Fatalf("insert received %d times, want 1", n)
+	}
+
+	srv.DropAfterNext("update", 1)
+	bulk = c.Bulk()
+	bulk.UpdateAll(nil, Inc("n", 1))
+	_, err := bulk.Run()
+	if berr, ok := err.(*BulkError); !ok || len(berr.Cases()) != 1 || !isNetworkError(berr.Cases()[0].Err) {
+		t.Fatalf("$inc after a broken connection: %#v", err)
+	}
+	if n, _ := c.Find(bson.M{"n": 1}).Count(); n != 2 {
+		t.Fatalf("$inc applied to %d documents, want 2 applied once", n)
+	}
+
+	srv.DropAfterNext("update", 1)
+	bulk = c.Bulk()
+	bulk.UpdateAll(nil, Set("seen", true))
+	if result, err := bulk.Run(); err != nil || result.Matched != 2 {
+		t.Fatalf("repeated $set: %+v %v", result, err)
+	}
+
+	sent := srv.Received("update")
+	srv.DropAfterNext("update", 1)
+	bulk = c.Bulk()
+	bulk.Update(bson.M{"n": 1}, Set("n", 2))
+	if _, err := bulk.Run(); err == nil || srv.Received("update") != sent+1 {
+		t.Fatalf("single update sent again after a broken connection: %v", err)
+	}
+	if n, _ := c.Find(bson.M{"n": 2}).Count(); n != 1 {
+		t.Fatalf("single update applied to %d documents", n)
+	}
+
+	srv.DropAfterNext("update", 1)
+	bulk = c.Bulk()
+	bulk.Upsert(bson.M{"name": "Ale"}, Set("name", "Ale M."))
+	if _, err := bulk.Run(); err == nil || srv.Received("update") != sent+2 {
+		t.Fatalf("upsert sent again after a broken connection: %v", err)
+	}
+	if n, _ := c.Find(bson.M{"name": "Ale M."}).Count(); n != 1 {
+		t.Fatalf("upsert inserted %d documents", n)
+	}
+
+	srv.DropAfterNext("update", 1)
+	bulk = c.Bulk()
+	bulk.Update(bson.M{"_id": 1}, Set("n", 5))
+	bulk.Upsert(bson.M{"_id": 4}, Set("n", 5))
+	if result, err := bulk.Run(); err != nil || srv.Received("update") != sent+4 {
+		t.Fatalf("updates by _id after a broken connection: %+v %v", result, err)
+	}
+	if n, _ := c.Find(bson.M{"n": 5}).Count(); n != 2 {
+		t.Fatalf("updates by _id applied to %d documents", n)
+	}
+
+	srv.DropAfterNext("insert", 1)
+	bulk = c.Bulk().Unordered()
+	bulk.Insert(bson.M{"n": 9})
+	if _, err := bulk.Run(); err == nil {
+		t.Fatal("insert without _id sent again after a broken connection")
+	}
+	if n, _ := c.Find(bson.M{"n": 9}).Count(); n != 1 {
+		t.Fatalf("%d documents inserted, want 1", n)
+	}
+
+	db.MaxConnectRetries = 0
+	bulk = c.Bulk()
+	bulk.Insert(bson.M{"_id": 3})
+	if result, err := bulk.Run(); err != nil || result.Inserted != 1 {
+		t.Fatalf("run without retries: %+v %v", result, err)
+	}
+}
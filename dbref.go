@@ -0,0 +1,107 @@
+package mdb
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+// FindRef returns a query that looks for the document in the provided
+// reference. If the reference includes the Database field, the document
+// is retrieved from that database rather than db.
+//
+// Unlike with mgo's FindRef, the query is retried on network errors like
+// any other Query:
+//
+//     var person Person
+//     err := db.FindRef(&post.Author).One(&person)
+//
+// Relevant documentation:
+//
+//     http://www.mongodb.org/display/DOCS/Database+References
+//
+func (db *Database) FindRef(ref *mgo.DBRef) *Query {
+	return db.refDB(ref).C(ref.Collection).FindId(ref.Id)
+}
+
+// ResolveRefs retrieves the documents of refs with a query per collection,
+// and stores them into result, which must be a pointer to a slice, in the
+// order of refs. The slice gets an element per reference: those of missing
+// documents are left zero, or nil for a slice of pointers.
+//
+// For example:
+//
+//     var people []*Person
+//     err := db.ResolveRefs(refs, &people)
+//
+func (db *Database) ResolveRefs(refs []mgo.DBRef, result interface{}) error {
+	rv := reflect.ValueOf(result)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("mdb: ResolveRefs needs a pointer to a slice, got %T", result)
+	}
+	type group struct {
+		db, collection string
+	}
+	var order []group
+	ids := map[group][]interface{}{}
+	for i, ref := range refs {
+		if ref.Collection == "" {
+			return fmt.Errorf("mdb: ResolveRefs: reference %d has no collection", i)
+		}
+		g := group{db.refDB(&refs[i]).Name, ref.Collection}
+		if _, ok := ids[g]; !ok {
+			order = append(order, g)
+		}
+		ids[g] = append(ids[g], ref.Id)
+	}
+	docs := map[group]map[interface{}]bson.Raw{}
+	for _, g := range order {
+		var raws []bson.Raw
+		c := db.DB(g.db).C(g.collection)
+		if err := c.Find(bson.D{{Name: "_id", Value: bson.D{{Name: "$in", Value: ids[g]}}}}).All(&raws); err != nil {
+			return err
+		}
+		byID := make(map[interface{}]bson.Raw, len(raws))
+		for _, raw := range raws {
+			var doc struct {
+				Id interface{} `bson:"_id"`
+			}
+			if err := raw.Unmarshal(&doc); err != nil {
+				return err
+			}
+			byID[refKey(doc.Id)] = raw
+		}
+		docs[g] = byID
+	}
+	slice := reflect.MakeSlice(rv.Elem().Type(), len(refs), len(refs))
+	for i, ref := range refs {
+		raw, ok := docs[group{db.refDB(&refs[i]).Name, ref.Collection}][refKey(ref.Id)]
+		if !ok {
+			continue
+		}
+		v := reflect.New(slice.Type().Elem())
+		if err := raw.Unmarshal(v.Interface()); err != nil {
+			return err
+		}
+		doc := v
+		if doc.Elem().Kind() == reflect.Ptr {
+			doc = doc.Elem()
+		}
+		if err := afterFind(doc.Interface()); err != nil {
+			return err
+		}
+		slice.Index(i).Set(v.Elem())
+	}
+	rv.Elem().Set(slice)
+	return nil
+}
+
+// refDB returns the database holding the document of ref.
+func (db *Database) refDB(ref *mgo.DBRef) *Database {
+	if ref.Database == "" || ref.Database == db.Name {
+		return db
+	}
+	return db.DB(ref.Database)
+}
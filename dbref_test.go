@@ -0,0 +1,56 @@
+package mdb
+
+import (
+	"testing"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+func TestFindRef(t *testing.T) {
+	srv, db := dialTest(t)
+	if err := db.C("people").Insert(bson.M{"_id": 1, "name": "Ale"}, bson.M{"_id": 2, "name": "Bob"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.DB("other").C("pets").Insert(bson.M{"_id": 1, "name": "Rex"}); err != nil {
+		t.Fatal(err)
+	}
+
+	faults := NewFaultInjector()
+	faults.FailNext("people", OpOne, 1, ErrFaultEOF)
+	db.SetFaultInjector(faults)
+	var doc struct{ Name string }
+	if err := db.FindRef(&mgo.DBRef{Collection: "people", Id: 2}).One(&doc); err != nil || doc.Name != "Bob" {
+		t.Fatalf("people ref: %+v %v", doc, err)
+	}
+	if faults.Hits(OpOne) != 1 {
+		t.Fatal("fault not injected")
+	}
+	if err := db.FindRef(&mgo.DBRef{Collection: "pets", Id: 1, Database: "other"}).One(&doc); err != nil || doc.Name != "Rex" {
+		t.Fatalf("cross-database ref: %+v %v", doc, err)
+	}
+	if err := db.FindRef(&mgo.DBRef{Collection: "pets", Id: 1}).One(&doc); err != mgo.ErrNotFound {
+		t.Fatalf("ref to missing document: %v", err)
+	}
+
+	refs := []mgo.DBRef{
+		{Collection: "people", Id: 2},
+		{Collection: "pets", Id: 1, Database: "other"},
+		{Collection: "people", Id: 3},
+		{Collection: "people", Id: 1, Database: "test"},
+	}
+	before := srv.Received("find")
+	var docs []*struct{ Name string }
+	if err := db.ResolveRefs(refs, &docs); err != nil {
+		t.Fatal(err)
+	}
+	if n := srv.Received("find") - before; n != 2 {
+		t.Fatalf("%d queries, want 2", n)
+	}
+	if len(docs) != 4 || docs[0].Name != "Bob" || docs[1].Name != "Rex" || docs[2] != nil || docs[3].Name != "Ale" {
+		t.Fatalf("resolved %+v", docs)
+	}
+	if err := db.ResolveRefs(refs, docs); err == nil {
+		t.Fatal("ResolveRefs accepted a slice")
+	}
+}
@@ -0,0 +1,84 @@
+package mdb
+
+import (
+	"reflect"
+	"strconv"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// DiffUpdate compares two versions of a document, as marshalled by bson,
+// and returns the update document turning old into new: changed and added
+// fields go into $set and removed fields into $unset. Nested documents are
+// compared field by field, as are arrays which kept their length; arrays
+// whose length changed are set as a whole.
+//
+// An empty update is returned when nothing changed, which the caller should
+// not send as MongoDB rejects it. For example:
+//
+//     update, err := mdb.DiffUpdate(&before, &after)
+//     if err == nil && len(update) > 0 {
+//         err = c.UpdateId(after.Id, update)
+//     }
+//
+func DiffUpdate(old, new interface{}) (bson.D, error) {
+	from, err := toDoc(old)
+	if err != nil {
+		return nil, err
+	}
+	to, err := toDoc(new)
+	if err != nil {
+		return nil, err
+	}
+	var d docDiff
+	d.docs("", from, to)
+	var update bson.D
+	if len(d.set) > 0 {
+		update = append(update, bson.DocElem{Name: "$set", Value: d.set})
+	}
+	if len(d.unset) > 0 {
+		update = append(update, bson.DocElem{Name: "$unset", Value: d.unset})
+	}
+	return update, nil
+}
+
+type docDiff struct {
+	set   bson.D
+	unset bson.D
+}
+
+func (d *docDiff) docs(prefix string, from, to bson.D) {
+	for _, e := range from {
+		if !hasField(to, e.Name) {
+			d.unset = append(d.unset, bson.DocElem{Name: joinPath(prefix, e.Name), Value: ""})
+		}
+	}
+	for _, e := range to {
+		path := joinPath(prefix, e.Name)
+		if !hasField(from, e.Name) {
+			d.set = append(d.set, bson.DocElem{Name: path, Value: e.Value})
+			continue
+		}
+		d.values(path, docValue(from, e.Name), e.Value)
+	}
+}
+
+func (d *docDiff) values(path string, from, to interface{}) {
+	switch to := to.(type) {
+	case bson.D:
+		if from, ok := from.(bson.D); ok {
+			d.docs(path, from, to)
+			return
+		}
+	case []interface{}:
+		if from, ok := from.([]interface{}); ok && len(from) == len(to) {
+			for i := range to {
+				d.values(joinPath(path, strconv.Itoa(i)), from[i], to[i])
+			}
+			return
+		}
+	}
+	if !reflect.DeepEqual(from, to) {
+		d.set = append(d.set, bson.DocElem{Name: path, Value: to})
+	}
+}
@@ -0,0 +1,69 @@
+package mdb
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+type diffItem struct {
+	Sku string `bson:"sku"`
+	Qty int    `bson:"qty"`
+}
+
+type diffOrder struct {
+	Id      int                        `bson:"_id"`
+	Status  string                     `bson:"status"`
+	Note    string                     `bson:"note,omitempty"`
+	Address struct{ City, Zip string } `bson:"address"`
+	Items   []diffItem                 `bson:"items"`
+	Tags    []string                   `bson:"tags"`
+}
+
+func TestDiffUpdate(t *testing.T) {
+	old := diffOrder{Id: 1, Status: "new", Note: "ring twice", Items: []diffItem{{"a", 1}, {"b", 2}}, Tags: []string{"x"}}
+	old.Address.City, old.Address.Zip = "Rome", "00100"
+	new := old
+	new.Status = "paid"
+	new.Note = ""
+	new.Address.Zip = "00118"
+	new.Items = []diffItem{{"a", 1}, {"b", 3}}
+	new.Tags = []string{"x", "y"}
+
+	update, err := DiffUpdate(old, &new)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := bson.D{
+		{Name: "$set", Value: bson.D{
+			{Name: "status", Value: "paid"},
+			{Name: "address.zip", Value: "00118"},
+			{Name: "items.1.qty", Value: 3},
+			{Name: "tags", Value: []interface{}{"x", "y"}},
+		}},
+		{Name: "$unset", Value: bson.D{{Name: "note", Value: ""}}},
+	}
+	if !reflect.DeepEqual(update, want) {
+		t.Fatalf("update:\n%#v\nwant:\n%#v", update, want)
+	}
+	if update, err := DiffUpdate(old, old); err != nil || len(update) != 0 {
+		t.Fatalf("update of unchanged document: %v %v", update, err)
+	}
+
+	_, db := dialTest(t)
+	c := db.C("orders")
+	if err := c.Insert(old); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.UpdateId(1, update); err != nil {
+		t.Fatal(err)
+	}
+	var stored diffOrder
+	if err := c.FindId(1).One(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(stored, new) {
+		t.Fatalf("stored:\n%+v\nwant:\n%+v", stored, new)
+	}
+}
@@ -0,0 +1,163 @@
+package mdb
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"syscall"
+
+	"github.com/globalsign/mgo"
+)
+
+// Op names an operation which may be intercepted by a FaultInjector.
+type Op string
+
+// Operations known to the FaultInjector. The empty Op matches any operation.
+const (
+	OpInsert         Op = "insert"
+	OpCount          Op = "count"
+	OpCreate         Op = "create"
+	OpDropCollection Op = "dropCollection"
+	OpDropIndex      Op = "dropIndex"
+	OpEnsureIndex    Op = "ensureIndex"
+	OpIndexes        Op = "indexes"
+	OpRemove         Op = "remove"
+	OpRemoveAll      Op = "removeAll"
+	OpUpdate         Op = "update"
+	OpUpdateAll      Op = "updateAll"
+	OpUpsert         Op = "upsert"
+	OpOne            Op = "one"
+	OpAll            Op = "all"
+	OpExplain        Op = "explain"
+	OpApply          Op = "apply"
+	OpIterAll        Op = "iterAll"
+	OpIterClose      Op = "iterClose"
+	OpIterNext       Op = "iterNext"
+	OpRun            Op = "run"
+	OpPipe           Op = "pipe"
+	OpBulk           Op = "bulk"
+)
+
+// Fault describes an error returned in place of the next Times operations
+// matching Collection and Op. An empty Collection or Op matches anything.
+type Fault struct {
+	Collection string
+	Op         Op
+	Err        error
+	Times      int
+}
+
+// FaultInjector makes operations of a Database fail with predefined errors
+// before they reach the server, so retry behaviour can be tested
+// deterministically.
+//
+// For example, to make the next two inserts into "people" fail with a
+// broken connection:
+//
+//     faults := mdb.NewFaultInjector()
+//     faults.FailNext("people", mdb.OpInsert, 2, mdb.ErrFaultEOF)
+//     db.SetFaultInjector(faults)
+//
+type FaultInjector struct {
+	mu     sync.Mutex
+	faults []*Fault
+	hits   map[Op]int
+}
+
+// NewFaultInjector returns an empty FaultInjector.
+func NewFaultInjector() *FaultInjector {
+	return &FaultInjector{hits: map[Op]int{}}
+}
+
+// Add schedules the provided fault. Faults are consumed in the order they
+// were added; faults with Times below 1 are ignored.
+func (f *FaultInjector) Add(fault Fault) {
+	if fault.Times <= 0 {
+		return
+	}
+	f.mu.Lock()
+	f.faults = append(f.faults, &fault)
+	f.mu.Unlock()
+}
+
+// FailNext makes the next n operations matching collection and op return err.
+// It does nothing when n is below 1.
+func (f *FaultInjector) FailNext(collection string, op Op, n int, err error) {
+	f.Add(Fault{Collection: collection, Op: op, Err: err, Times: n})
+}
+
+// Pending returns the number of faults which are yet to be injected.
+func (f *FaultInjector) Pending() (n int) {
+	f.mu.Lock()
+	for _, fault := range f.faults {
+		n += fault.Times
+	}
+	f.mu.Unlock()
+	return n
+}
+
+// Hits returns how many faults were injected into operations of the given
+// kind. The empty Op returns the total.
+func (f *FaultInjector) Hits(op Op) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if op != "" {
+		return f.hits[op]
+	}
+	var n int
+	for _, hits := range f.hits {
+		n += hits
+	}
+	return n
+}
+
+// Reset drops every pending fault and clears the hit counters.
+func (f *FaultInjector) Reset() {
+	f.mu.Lock()
+	f.faults = nil
+	f.hits = map[Op]int{}
+	f.mu.Unlock()
+}
+
+// check returns the error to inject into the given operation, if any.
+func (f *FaultInjector) check(collection string, op Op) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, fault := range f.faults {
+		if fault.Collection != "" && fault.Collection != collection {
+			continue
+		}
+		if fault.Op != "" && fault.Op != op {
+			continue
+		}
+		fault.Times--
+		if fault.Times <= 0 {
+			f.faults = append(f.faults[:i], f.faults[i+1:]...)
+		}
+		f.hits[op]++
+		return fault.Err
+	}
+	return nil
+}
+
+// Errors commonly seen by mgo when a connection breaks, ready to be used with
+// a FaultInjector.
+var (
+	ErrFaultEOF     = io.EOF
+	ErrFaultClosed  = errors.New("Closed explicitly")
+	ErrFaultReset   = &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}
+	ErrFaultTimeout = &net.OpError{Op: "read", Net: "tcp", Err: timeoutError{}}
+)
+
+// FaultServerError returns an error as reported by the server with the
+// given code, such as 11000 for a duplicate key.
+func FaultServerError(code int, message string) error {
+	return &mgo.QueryError{Code: code, Message: message}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
@@ -0,0 +1,79 @@
+package mdb
+
+import (
+	"testing"
+)
+
+func TestFaultInjector(t *testing.T) {
+	faults := NewFaultInjector()
+	faults.FailNext("people", OpInsert, 2, ErrFaultEOF)
+	faults.FailNext("", OpOne, 1, ErrFaultClosed)
+	faults.FailNext("", "", 0, ErrFaultReset)
+
+	if err := faults.check("other", OpInsert); err != nil {
+		t.Fatalf("unexpected fault on other collection: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := faults.check("people", OpInsert); err != ErrFaultEOF {
+			t.Fatalf("insert %d: got %v, want EOF", i, err)
+		}
+	}
+	if err := faults.check("people", OpInsert); err != nil {
+		t.Fatalf("fault injected more than twice: %v", err)
+	}
+	if err := faults.check("other", OpOne); err != ErrFaultClosed {
+		t.Fatalf("got %v, want Closed explicitly", err)
+	}
+	if n := faults.Pending(); n != 0 {
+		t.Fatalf("pending faults = %d, want 0", n)
+	}
+	if n := faults.Hits(""); n != 3 {
+		t.Fatalf("hits = %d, want 3", n)
+	}
+}
+
+func TestFaultErrorsAreRetried(t *testing.T) {
+	for _, err := range []error{ErrFaultEOF, ErrFaultClosed, ErrFaultReset, ErrFaultTimeout} {
+		if !isNetworkError(err) {
+			t.Errorf("%v is not treated as a network error", err)
+		}
+	}
+	if isNetworkError(FaultServerError(11000, "E11000 duplicate key error")) {
+		t.Error("server errors must not be retried")
+	}
+}
+
+func TestCopiesKeepRetries(t *testing.T) {
+	_, db := dialTest(t)
+	faults := NewFaultInjector()
+	db.SetFaultInjector(faults)
+	for _, copied := range []*Database{db.Copy(), db.Clone()} {
+		faults.FailNext("people", OpInsert, 1, ErrFaultEOF)
+		if err := copied.C("people").Insert(map[string]int{"n": 1}); err != nil {
+			t.Fatal(err)
+		}
+		copied.Close()
+	}
+	if n, err := db.C("people").Count(); err != nil || n != 2 {
+		t.Fatalf("people count = %d, %v; want 2", n, err)
+	}
+}
+
+func TestIterNextFault(t *testing.T) {
+	_, db := dialTest(t)
+	c := db.C("people")
+	if err := c.Insert(map[string]int{"_id": 1}, map[string]int{"_id": 2}); err != nil {
+		t.Fatal(err)
+	}
+	faults := NewFaultInjector()
+	db.SetFaultInjector(faults)
+	iter := c.Find(nil).Iter()
+	var doc map[string]int
+	if !iter.Next(&doc) {
+		t.Fatal(iter.Close())
+	}
+	faults.FailNext("people", OpIterNext, 1, ErrFaultReset)
+	if iter.Next(&doc) || iter.Err() != ErrFaultReset || iter.Close() != ErrFaultReset {
+		t.Fatalf("injected fault not reported by the iterator: %v", iter.Err())
+	}
+}
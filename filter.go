@@ -0,0 +1,340 @@
+package mdb
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// Filter is a query document built with Where, And, Or and Nor, so that
+// operators are spelled by methods rather than by hand. A Filter may be
+// passed as the query of Find, Remove, RemoveAll, Update and UpdateAll, and
+// D returns it as a bson.D.
+//
+// Operators following Where apply to its field, and are combined when they
+// apply to the same field:
+//
+//     f := mdb.Where("age").Gte(18).Lt(65).Where("status").In("a", "b")
+//     // {age: {$gte: 18, $lt: 65}, status: {$in: ["a", "b"]}}
+//
+//     f = mdb.Where("age").Gte(18).And(mdb.Where("status").In("a", "b"))
+//     // {$and: [{age: {$gte: 18}}, {status: {$in: ["a", "b"]}}]}
+//
+//     n, err := c.Find(f).Count()
+//
+// A field given to Where without a condition, or an operator used without
+// Where, makes the filter invalid rather than matching every document: Err
+// returns the problem, and queries given the filter fail with it.
+//
+// Filters are values: adding operators returns a new Filter and leaves the
+// original unchanged.
+type Filter struct {
+	doc   bson.D
+	field string
+	not   bool
+	open  bool // field given to Where has no condition yet
+	err   error
+}
+
+// Where starts a filter on field, named with dots for nested fields.
+func Where(field string) Filter {
+	return Filter{field: field, open: true}
+}
+
+// Where continues the filter with conditions on another field, which must
+// also hold.
+func (f Filter) Where(field string) Filter {
+	if f.err == nil {
+		f.err = f.Err()
+	}
+	f.field, f.not, f.open = field, false, true
+	return f
+}
+
+// D returns the filter as a query document, which is only complete when Err
+// returns nil.
+func (f Filter) D() bson.D {
+	if f.doc == nil {
+		return bson.D{}
+	}
+	return f.doc
+}
+
+// Err returns the first problem found while building the filter, such as a
+// field given to Where without a condition.
+func (f Filter) Err() error {
+	if f.err == nil && f.open {
+		return fmt.Errorf("mdb: filter field %q has no condition", f.field)
+	}
+	return f.err
+}
+
+// GetBSON implements bson.Getter, so that a Filter marshals as its document,
+// or fails with its problem.
+func (f Filter) GetBSON() (interface{}, error) {
+	if err := f.Err(); err != nil {
+		return nil, err
+	}
+	return f.D(), nil
+}
+
+// String returns the filter as Extended JSON.
+func (f Filter) String() string {
+	var b strings.Builder
+	writeJSON(&b, append(bson.D{}, f.doc...))
+	return b.String()
+}
+
+// writeJSON writes v as Extended JSON, keeping the order of bson.D fields.
+func writeJSON(b *strings.Builder, v interface{}) {
+	switch v := v.(type) {
+	case bson.D:
+		b.WriteByte('{')
+		for i, e := range v {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(strconv.Quote(e.Name))
+			b.WriteByte(':')
+			writeJSON(b, e.Value)
+		}
+		b.WriteByte('}')
+	case bson.M:
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		d := make(bson.D, len(names))
+		for i, name := range names {
+			d[i] = bson.DocElem{Name: name, Value: v[name]}
+		}
+		writeJSON(b, d)
+	case []bson.M:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			list[i] = item
+		}
+		writeJSON(b, list)
+	case []interface{}:
+		b.WriteByte('[')
+		for i, item := range v {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			writeJSON(b, item)
+		}
+		b.WriteByte(']')
+	default:
+		data, err := bson.MarshalJSON(v)
+		if err != nil {
+			fmt.Fprint(b, v)
+			return
+		}
+		b.Write(bytes.TrimSpace(data))
+	}
+}
+
+// And returns a filter matching documents which match f and every filter.
+func (f Filter) And(filters ...Filter) Filter {
+	return logical("$and", append([]Filter{f}, filters...))
+}
+
+// Or returns a filter matching documents which match f or any filter.
+func (f Filter) Or(filters ...Filter) Filter {
+	return logical("$or", append([]Filter{f}, filters...))
+}
+
+// And returns a filter matching documents which match every filter.
+func And(filters ...Filter) Filter {
+	return logical("$and", filters)
+}
+
+// Or returns a filter matching documents which match any filter.
+func Or(filters ...Filter) Filter {
+	return logical("$or", filters)
+}
+
+// Nor returns a filter matching documents which match none of filters.
+func Nor(filters ...Filter) Filter {
+	return logical("$nor", filters)
+}
+
+// logical combines filters with op, keeping the first of their problems.
+func logical(op string, filters []Filter) Filter {
+	var result Filter
+	list := make([]interface{}, 0, len(filters))
+	for _, f := range filters {
+		if err := f.Err(); err != nil && result.err == nil {
+			result.err = err
+		}
+		if d := f.D(); len(d) > 0 {
+			list = append(list, d)
+		}
+	}
+	switch {
+	case len(list) == 0:
+	case len(list) == 1 && op != "$nor":
+		result.doc = list[0].(bson.D)
+	default:
+		result.doc = bson.D{{Name: op, Value: list}}
+	}
+	return result
+}
+
+// Not negates the next operator on the field, as in Where("age").Not().Gt(5).
+func (f Filter) Not() Filter {
+	f.not = !f.not
+	return f
+}
+
+// Eq matches values equal to value.
+func (f Filter) Eq(value interface{}) Filter { return f.op("$eq", value) }
+
+// Ne matches values not equal to value, and documents without the field.
+func (f Filter) Ne(value interface{}) Filter { return f.op("$ne", value) }
+
+// Gt matches values greater than value.
+func (f Filter) Gt(value interface{}) Filter { return f.op("$gt", value) }
+
+// Gte matches values greater than or equal to value.
+func (f Filter) Gte(value interface{}) Filter { return f.op("$gte", value) }
+
+// Lt matches values less than value.
+func (f Filter) Lt(value interface{}) Filter { return f.op("$lt", value) }
+
+// Lte matches values less than or equal to value.
+func (f Filter) Lte(value interface{}) Filter { return f.op("$lte", value) }
+
+// In matches values equal to any of values.
+func (f Filter) In(values ...interface{}) Filter { return f.op("$in", valueList(values)) }
+
+// Nin matches values equal to none of values.
+func (f Filter) Nin(values ...interface{}) Filter { return f.op("$nin", valueList(values)) }
+
+// Exists matches documents which hold the field, or which do not if exists
+// is false.
+func (f Filter) Exists(exists bool) Filter { return f.op("$exists", exists) }
+
+// Type matches values of the given BSON type, by alias such as "string"
+// or by number.
+func (f Filter) Type(bsonType interface{}) Filter { return f.op("$type", bsonType) }
+
+// Regex matches strings matching pattern, with options such as "i".
+func (f Filter) Regex(pattern, options string) Filter {
+	re := bson.RegEx{Pattern: pattern, Options: options}
+	if f.not {
+		// $not takes a regular expression rather than a $regex operator.
+		f.not = false
+		return f.op("$not", re)
+	}
+	return f.op("$regex", re)
+}
+
+// Mod matches numbers whose remainder by divisor is remainder.
+func (f Filter) Mod(divisor, remainder int) Filter {
+	return f.op("$mod", []interface{}{divisor, remainder})
+}
+
+// Size matches arrays with n elements.
+func (f Filter) Size(n int) Filter { return f.op("$size", n) }
+
+// All matches arrays holding every one of values.
+func (f Filter) All(values ...interface{}) Filter { return f.op("$all", valueList(values)) }
+
+// ElemMatch matches arrays with an element matching filter.
+func (f Filter) ElemMatch(filter Filter) Filter {
+	f = f.op("$elemMatch", filter.D())
+	if f.err == nil {
+		f.err = filter.Err()
+	}
+	return f
+}
+
+// GeoWithin matches locations within geometry, a GeoJSON Polygon or
+// MultiPolygon.
+func (f Filter) GeoWithin(geometry interface{}) Filter {
+	return f.op("$geoWithin", bson.D{{Name: "$geometry", Value: geometry}})
+}
+
+// GeoIntersects matches locations intersecting geometry, a GeoJSON object.
+func (f Filter) GeoIntersects(geometry interface{}) Filter {
+	return f.op("$geoIntersects", bson.D{{Name: "$geometry", Value: geometry}})
+}
+
+// NearSphere matches locations at most maxDistance meters from point,
+// nearest first, and needs a 2dsphere index. A zero maxDistance sets no
+// limit.
+func (f Filter) NearSphere(point interface{}, maxDistance float64) Filter {
+	near := bson.D{{Name: "$geometry", Value: point}}
+	if maxDistance > 0 {
+		near = append(near, bson.DocElem{Name: "$maxDistance", Value: maxDistance})
+	}
+	return f.op("$nearSphere", near)
+}
+
+// Point returns a GeoJSON point, for use with the geospatial operators.
+func Point(longitude, latitude float64) bson.D {
+	return bson.D{{Name: "type", Value: "Point"}, {Name: "coordinates", Value: []float64{longitude, latitude}}}
+}
+
+// Polygon returns a GeoJSON polygon with a single ring, given as
+// [longitude, latitude] pairs. The ring is closed if needed.
+func Polygon(ring ...[2]float64) bson.D {
+	coords := make([]interface{}, 0, len(ring)+1)
+	for _, p := range ring {
+		coords = append(coords, []float64{p[0], p[1]})
+	}
+	if len(ring) > 0 && ring[0] != ring[len(ring)-1] {
+		coords = append(coords, []float64{ring[0][0], ring[0][1]})
+	}
+	return bson.D{{Name: "type", Value: "Polygon"}, {Name: "coordinates", Value: []interface{}{coords}}}
+}
+
+func valueList(values []interface{}) []interface{} {
+	if values == nil {
+		return []interface{}{}
+	}
+	return values
+}
+
+// op adds an operator on the current field. Equality is written as a plain
+// value unless other operators apply to the field.
+func (f Filter) op(name string, value interface{}) Filter {
+	if f.field == "" {
+		if f.err == nil {
+			f.err = fmt.Errorf("mdb: filter operator %s used without Where", name)
+		}
+		return f
+	}
+	if f.not {
+		name, value = "$not", bson.D{{Name: name, Value: value}}
+		f.not = false
+	}
+	f.open = false
+	doc := append(bson.D(nil), f.doc...)
+	for i, e := range doc {
+		if e.Name != f.field {
+			continue
+		}
+		ops, ok := e.Value.(bson.D)
+		if !ok || !isOperatorDoc(ops) {
+			ops = bson.D{{Name: "$eq", Value: e.Value}}
+		}
+		doc[i].Value = append(append(bson.D(nil), ops...), bson.DocElem{Name: name, Value: value})
+		f.doc = doc
+		return f
+	}
+	if name == "$eq" {
+		if d, ok := value.(bson.D); !ok || !isOperatorDoc(d) {
+			f.doc = append(doc, bson.DocElem{Name: f.field, Value: value})
+			return f
+		}
+	}
+	f.doc = append(doc, bson.DocElem{Name: f.field, Value: bson.D{{Name: name, Value: value}}})
+	return f
+}
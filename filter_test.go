@@ -0,0 +1,110 @@
+package mdb
+
+import (
+	"testing"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+func TestFilterDocuments(t *testing.T) {
+	tests := []struct {
+		filter Filter
+		want   string
+	}{
+		{Where("name").Eq("Ale"), `{"name":"Ale"}`},
+		{Where("age").Gte(18).Lt(65), `{"age":{"$gte":18,"$lt":65}}`},
+		{Where("age").Eq(18).Ne(nil), `{"age":{"$eq":18,"$ne":null}}`},
+		{Where("age").Gte(18).Where("status").In("a", "b"), `{"age":{"$gte":18},"status":{"$in":["a","b"]}}`},
+		{Where("age").Gte(18).And(Where("status").In("a", "b")), `{"$and":[{"age":{"$gte":18}},{"status":{"$in":["a","b"]}}]}`},
+		{Or(Where("a").Eq(1), Where("b").Exists(false)), `{"$or":[{"a":1},{"b":{"$exists":false}}]}`},
+		{Nor(Where("a").Eq(1)), `{"$nor":[{"a":1}]}`},
+		{And(Where("a").Eq(1)), `{"a":1}`},
+		{Where("age").Not().Gt(5), `{"age":{"$not":{"$gt":5}}}`},
+		{Where("name").Regex("^a", "i"), `{"name":{"$regex":{"$regex":"^a","$options":"i"}}}`},
+		{Where("name").Not().Regex("^a", ""), `{"name":{"$not":{"$regex":"^a","$options":""}}}`},
+		{Where("n").Mod(4, 1).Type("int"), `{"n":{"$mod":[4,1],"$type":"int"}}`},
+		{Where("tags").All("x", "y").Size(2), `{"tags":{"$all":["x","y"],"$size":2}}`},
+		{Where("items").ElemMatch(Where("qty").Gt(1)), `{"items":{"$elemMatch":{"qty":{"$gt":1}}}}`},
+		{Where("loc").NearSphere(Point(12.5, 41.9), 1000), `{"loc":{"$nearSphere":{"$geometry":{"type":"Point","coordinates":[12.5,41.9]},"$maxDistance":1000}}}`},
+		{Where("loc").GeoWithin(Polygon([2]float64{0, 0}, [2]float64{1, 0}, [2]float64{1, 1})), `{"loc":{"$geoWithin":{"$geometry":{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,0]]]}}}}`},
+		{Filter{}, `{}`},
+	}
+	for _, test := range tests {
+		if got := test.filter.String(); got != test.want {
+			t.Errorf("got %s, want %s", got, test.want)
+		}
+	}
+
+	base := Where("a").Eq(1)
+	base.Where("b").Eq(2)
+	if got := base.String(); got != `{"a":1}` {
+		t.Fatalf("filter modified by derived filter: %s", got)
+	}
+
+	for _, f := range []Filter{
+		Where("x"),
+		Where("a").Eq(1).Where("b"),
+		Where("a").Where("b").Eq(1),
+		Where("a").Not(),
+		And(Where("a").Eq(1), Where("b")),
+		Where("a").Eq(1).Or(Where("b")),
+		Where("items").ElemMatch(Where("qty")),
+		Filter{}.Eq(1),
+	} {
+		if f.Err() == nil {
+			t.Errorf("filter %s without condition has no error", f)
+		}
+		if _, err := f.GetBSON(); err == nil {
+			t.Errorf("filter %s without condition marshalled", f)
+		}
+	}
+	if err := NewPipeline().Match(Where("x")).Err(); err == nil {
+		t.Error("pipeline matching a field without condition has no error")
+	}
+	if err := Pull("items", Where("qty")).Err(); err == nil {
+		t.Error("update pulling with a field without condition has no error")
+	}
+	if err := Set("a", 1).ArrayFilters(Where("item.qty")).Err(); err == nil {
+		t.Error("update with an array filter without condition has no error")
+	}
+}
+
+func TestFilterQueries(t *testing.T) {
+	_, db := dialTest(t)
+	c := db.C("people")
+	if err := c.Insert(
+		bson.M{"_id": 1, "age": 15, "status": "a", "name": "Ale"},
+		bson.M{"_id": 2, "age": 30, "status": "b", "name": "bob"},
+		bson.M{"_id": 3, "age": 40, "status": "c", "name": "Cla"},
+	); err != nil {
+		t.Fatal(err)
+	}
+	adults := Where("age").Gte(18).And(Where("status").In("a", "b"))
+	if n, err := c.Find(adults).Count(); err != nil || n != 1 {
+		t.Fatalf("count: %d %v", n, err)
+	}
+	if _, err := c.UpdateAll(Where("name").Regex("^[a-b]", "i"), bson.M{"$set": bson.M{"seen": true}}); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := c.Find(Where("seen").Exists(true)).Count(); n != 2 {
+		t.Fatalf("updated %d documents", n)
+	}
+	if err := c.Remove(Where("age").Not().Lt(35)); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := c.Find(nil).Count(); n != 2 {
+		t.Fatalf("%d documents left", n)
+	}
+	if err := c.Find(Where("status")).One(&bson.M{}); err == nil || err.Error() != Where("status").Err().Error() {
+		t.Fatalf("find with a field without condition: %v", err)
+	}
+	if _, err := c.Find(And(Where("age").Gt(1), Where("status"))).Count(); err == nil {
+		t.Fatal("count with a field without condition accepted")
+	}
+	if _, err := c.RemoveAll(Where("status")); err == nil {
+		t.Fatal("removal with a field without condition accepted")
+	}
+	if n, _ := c.Find(nil).Count(); n != 2 {
+		t.Fatalf("%d documents left after a removal without condition", n)
+	}
+}
@@ -0,0 +1,290 @@
+package mdb
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+// LoadFixture inserts docs into the collection. Docs may be any values
+// accepted by Insert, such as structs, bson.M or the documents returned by
+// ReadFixtureFile.
+func (c *Collection) LoadFixture(docs ...interface{}) error {
+	if len(docs) == 0 {
+		return nil
+	}
+	return c.Insert(docs...)
+}
+
+// LoadFixtureFile reads the documents of a fixture file and inserts them into
+// the collection. See ReadFixtureFile for the supported formats.
+func (c *Collection) LoadFixtureFile(path string) error {
+	docs, err := ReadFixtureFile(path)
+	if err != nil {
+		return err
+	}
+	return c.LoadFixture(docs...)
+}
+
+// LoadFixtureDir loads every fixture file in dir into the collection named
+// after the file, so that people.json or people.jsonl is loaded into the
+// people collection. Files with other extensions are ignored.
+func (db *Database) LoadFixtureDir(dir string) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		ext := filepath.Ext(f.Name())
+		if f.IsDir() || !isFixtureExt(ext) {
+			continue
+		}
+		name := strings.TrimSuffix(f.Name(), ext)
+		if err := db.C(name).LoadFixtureFile(filepath.Join(dir, f.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isFixtureExt(ext string) bool {
+	switch ext {
+	case ".json", ".jsonl", ".ndjson":
+		return true
+	}
+	return false
+}
+
+// ReadFixtureFile reads documents written in MongoDB Extended JSON, such as
+// those produced by mongoexport. Files ending in .jsonl or .ndjson hold one
+// document per line; other files hold either a single document or an array
+// of documents.
+//
+// For example:
+//
+//     {"_id": {"$oid": "5b1e6f5e2d1f4a0001000001"}, "name": "Ale", "joined": {"$date": "2018-06-11T00:00:00Z"}}
+//
+func ReadFixtureFile(path string) ([]interface{}, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	switch filepath.Ext(path) {
+	case ".jsonl", ".ndjson":
+		return readJSONLines(path, data)
+	}
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return nil, nil
+	}
+	if data[0] != '[' {
+		var doc bson.M
+		if err := bson.UnmarshalJSON(data, &doc); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		return []interface{}{doc}, nil
+	}
+	var list []bson.M
+	if err := bson.UnmarshalJSON(data, &list); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	docs := make([]interface{}, len(list))
+	for i, doc := range list {
+		docs[i] = doc
+	}
+	return docs, nil
+}
+
+func readJSONLines(path string, data []byte) ([]interface{}, error) {
+	var docs []interface{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, 16*1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var doc bson.M
+		if err := bson.UnmarshalJSON(line, &doc); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, n, err)
+		}
+		docs = append(docs, doc)
+	}
+	return docs, scanner.Err()
+}
+
+// Truncate removes every document from the collection, keeping the
+// collection and its indexes. On a collection in soft-delete mode the
+// documents are removed for good rather than marked as deleted.
+func (c *Collection) Truncate() error {
+	cc := *c
+	cc.softDelete = nil
+	_, err := cc.RemoveAll(nil)
+	return err
+}
+
+// DropCollections drops the named collections, ignoring the ones which
+// do not exist. It is meant to reset state between tests.
+func (db *Database) DropCollections(names ...string) error {
+	for _, name := range names {
+		err := db.C(name).DropCollection()
+		if err != nil && !isNamespaceNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// isNamespaceNotFound reports whether err says the collection does not exist.
+func isNamespaceNotFound(err error) bool {
+	if qerr, ok := err.(*mgo.QueryError); ok && qerr.Code == 26 {
+		return true
+	}
+	return strings.Contains(err.Error(), "ns not found")
+}
+
+// Snapshot returns every document of the collection ordered by _id, with
+// the fields named in ignore removed. Nested fields may be named with dots.
+func (c *Collection) Snapshot(ignore ...string) ([]bson.M, error) {
+	var docs []bson.M
+	if err := c.Find(nil).Sort("_id").All(&docs); err != nil {
+		return nil, err
+	}
+	for _, doc := range docs {
+		for _, path := range ignore {
+			deletePath(doc, path)
+		}
+	}
+	return docs, nil
+}
+
+// WriteGolden writes a snapshot of the collection to path as Extended JSON,
+// one document per line. See Snapshot for the meaning of ignore.
+func (c *Collection) WriteGolden(path string, ignore ...string) error {
+	docs, err := c.Snapshot(ignore...)
+	if err != nil {
+		return err
+	}
+	lines, err := goldenLines(docs)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, []byte(strings.Join(lines, "")), 0644)
+}
+
+// CompareGolden compares a snapshot of the collection against the golden
+// file at path, as written by WriteGolden, and returns a *GoldenError
+// describing the differences if they do not match.
+//
+// A common pattern is to rewrite golden files behind a test flag:
+//
+//     if *update {
+//         err = c.WriteGolden("testdata/people.golden.jsonl", "createdAt")
+//     } else {
+//         err = c.CompareGolden("testdata/people.golden.jsonl", "createdAt")
+//     }
+//
+func (c *Collection) CompareGolden(path string, ignore ...string) error {
+	docs, err := c.Snapshot(ignore...)
+	if err != nil {
+		return err
+	}
+	got, err := goldenLines(docs)
+	if err != nil {
+		return err
+	}
+	fixtures, err := ReadFixtureFile(path)
+	if err != nil {
+		return err
+	}
+	want := make([]string, len(fixtures))
+	for i, doc := range fixtures {
+		for _, p := range ignore {
+			deletePath(doc.(bson.M), p)
+		}
+		data, err := bson.MarshalJSON(doc)
+		if err != nil {
+			return err
+		}
+		want[i] = string(data)
+	}
+	var diff []string
+	for i := 0; i < len(got) || i < len(want); i++ {
+		var g, w string
+		if i < len(got) {
+			g = got[i]
+		}
+		if i < len(want) {
+			w = want[i]
+		}
+		if g != w {
+			diff = append(diff, fmt.Sprintf("document %d:\n- %s+ %s", i, orNone(w), orNone(g)))
+		}
+	}
+	if len(diff) > 0 {
+		return &GoldenError{Collection: c.Name, Path: path, Diff: diff}
+	}
+	return nil
+}
+
+func orNone(line string) string {
+	if line == "" {
+		return "(none)\n"
+	}
+	return line
+}
+
+// GoldenError is returned by CompareGolden when the collection contents
+// differ from the golden file.
+type GoldenError struct {
+	Collection string
+	Path       string
+	Diff       []string // One entry per differing document, "-" is expected and "+" is actual.
+}
+
+func (e *GoldenError) Error() string {
+	return fmt.Sprintf("collection %s does not match %s:\n%s", e.Collection, e.Path, strings.Join(e.Diff, ""))
+}
+
+// goldenLines encodes docs as newline terminated Extended JSON lines.
+func goldenLines(docs []bson.M) ([]string, error) {
+	lines := make([]string, len(docs))
+	for i, doc := range docs {
+		data, err := bson.MarshalJSON(doc)
+		if err != nil {
+			return nil, err
+		}
+		lines[i] = string(data)
+	}
+	return lines, nil
+}
+
+// deletePath removes the field at a dotted path from doc.
+func deletePath(doc map[string]interface{}, path string) {
+	parts := strings.SplitN(path, ".", 2)
+	if len(parts) == 1 {
+		delete(doc, path)
+		return
+	}
+	switch sub := doc[parts[0]].(type) {
+	case bson.M:
+		deletePath(sub, parts[1])
+	case map[string]interface{}:
+		deletePath(sub, parts[1])
+	case []interface{}:
+		for _, item := range sub {
+			switch m := item.(type) {
+			case bson.M:
+				deletePath(m, parts[1])
+			case map[string]interface{}:
+				deletePath(m, parts[1])
+			}
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package mdb
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/ti/mdb/mdbtest"
+)
+
+// dialTest connects to an in-process mdbtest server for the duration of the test.
+func dialTest(t *testing.T) (*mdbtest.Server, *Database) {
+	srv := mdbtest.NewServer()
+	db, err := Dial(srv.URL("test"))
+	if err != nil {
+		srv.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		srv.Close()
+	})
+	return srv, db
+}
+
+func TestFixtures(t *testing.T) {
+	_, db := dialTest(t)
+	dir := t.TempDir()
+	people := `{"_id": {"$oid": "5b1e6f5e2d1f4a0001000001"}, "name": "Ale", "joined": {"$date": "2018-06-11T00:00:00Z"}}
+
+{"_id": {"$oid": "5b1e6f5e2d1f4a0001000002"}, "name": "Cla"}
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, "people.jsonl"), []byte(people), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "pets.json"), []byte(`[{"name": "Rex"}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.LoadFixtureDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := db.C("people").Count(); err != nil || n != 2 {
+		t.Fatalf("people count = %d, %v; want 2", n, err)
+	}
+	if err := db.C("pets").LoadFixture(bson.M{"name": "Tom"}); err != nil {
+		t.Fatal(err)
+	}
+
+	golden := filepath.Join(dir, "people.golden.jsonl")
+	if err := db.C("people").WriteGolden(golden); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.C("people").CompareGolden(golden); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.C("people").UpdateId(bson.ObjectIdHex("5b1e6f5e2d1f4a0001000002"), bson.M{"$set": bson.M{"name": "Bob"}}); err != nil {
+		t.Fatal(err)
+	}
+	err := db.C("people").CompareGolden(golden)
+	if gerr, ok := err.(*GoldenError); !ok || len(gerr.Diff) != 1 {
+		t.Fatalf("got %v, want a single document difference", err)
+	}
+	if err := db.C("people").CompareGolden(golden, "name"); err != nil {
+		t.Fatalf("ignored field reported as different: %v", err)
+	}
+
+	if err := db.C("pets").Truncate(); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := db.C("pets").Count(); err != nil || n != 0 {
+		t.Fatalf("pets count = %d, %v; want 0", n, err)
+	}
+	if err := db.DropCollections("people", "missing"); err != nil {
+		t.Fatal(err)
+	}
+}
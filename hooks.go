@@ -0,0 +1,118 @@
+package mdb
+
+import (
+	"reflect"
+)
+
+// Documents may implement any of the following interfaces to be called
+// around the operations of a Collection. Hooks which modify the document
+// must be defined on a pointer receiver, and the document passed as a
+// pointer, as in c.Insert(&person).
+//
+// A hook returning an error aborts the operation before it reaches the
+// server, except for AfterInsert and AfterFind which run once the
+// operation succeeded and have their error returned by it.
+
+// BeforeInserter is called by Insert before a document is inserted, and is
+// the place to set defaults and normalize fields.
+type BeforeInserter interface {
+	BeforeInsert() error
+}
+
+// AfterInserter is called by Insert after the documents were inserted.
+type AfterInserter interface {
+	AfterInsert() error
+}
+
+// BeforeUpdater is called by Update, UpdateId, Upsert and UpsertId when the
+// update document is a replacement document implementing it.
+type BeforeUpdater interface {
+	BeforeUpdate() error
+}
+
+// AfterFinder is called when a document is decoded from query results by
+// Query.One, Query.All, Query.Apply, Iter.Next and Iter.All.
+type AfterFinder interface {
+	AfterFind() error
+}
+
+// Validator is called before a document is written by Insert, Update,
+// UpdateId, Upsert and UpsertId, after the Before hooks have run. An invalid
+// document is never sent to the server.
+type Validator interface {
+	Validate() error
+}
+
+// beforeInsert runs the BeforeInsert and Validate hooks of docs.
+func beforeInsert(docs []interface{}) error {
+	for _, doc := range docs {
+		if h, ok := doc.(BeforeInserter); ok {
+			if err := h.BeforeInsert(); err != nil {
+				return err
+			}
+		}
+		if v, ok := doc.(Validator); ok {
+			if err := v.Validate(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// afterInsert runs the AfterInsert hooks of docs.
+func afterInsert(docs []interface{}) error {
+	for _, doc := range docs {
+		if h, ok := doc.(AfterInserter); ok {
+			if err := h.AfterInsert(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// beforeUpdate runs the BeforeUpdate and Validate hooks of an update
+// document.
+func beforeUpdate(update interface{}) error {
+	if h, ok := update.(BeforeUpdater); ok {
+		if err := h.BeforeUpdate(); err != nil {
+			return err
+		}
+	}
+	if v, ok := update.(Validator); ok {
+		return v.Validate()
+	}
+	return nil
+}
+
+// afterFind runs the AfterFind hook of a decoded result, or of each of its
+// elements if result points to a slice.
+func afterFind(result interface{}) error {
+	if h, ok := result.(AfterFinder); ok {
+		return h.AfterFind()
+	}
+	v := reflect.ValueOf(result)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Slice {
+		return nil
+	}
+	slice := v.Elem()
+	elem := slice.Type().Elem()
+	if !elem.Implements(afterFinderType) && !reflect.PtrTo(elem).Implements(afterFinderType) {
+		return nil
+	}
+	for i := 0; i < slice.Len(); i++ {
+		item := slice.Index(i)
+		if item.Kind() != reflect.Ptr && item.CanAddr() {
+			item = item.Addr()
+		}
+		if h, ok := item.Interface().(AfterFinder); ok && !(item.Kind() == reflect.Ptr && item.IsNil()) {
+			if err := h.AfterFind(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+var afterFinderType = reflect.TypeOf((*AfterFinder)(nil)).Elem()
@@ -0,0 +1,82 @@
+package mdb
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+type hookedPerson struct {
+	Name   string
+	Email  string
+	Loaded bool `bson:"-"`
+
+	inserted bool
+}
+
+func (p *hookedPerson) BeforeInsert() error {
+	p.Email = strings.ToLower(p.Email)
+	return nil
+}
+
+func (p *hookedPerson) AfterInsert() error {
+	p.inserted = true
+	return nil
+}
+
+func (p *hookedPerson) BeforeUpdate() error {
+	return p.BeforeInsert()
+}
+
+func (p *hookedPerson) Validate() error {
+	if p.Name == "" {
+		return errors.New("name is required")
+	}
+	return nil
+}
+
+func (p *hookedPerson) AfterFind() error {
+	p.Loaded = true
+	return nil
+}
+
+func TestHooks(t *testing.T) {
+	srv, db := dialTest(t)
+	c := db.C("people")
+
+	ale := &hookedPerson{Name: "Ale", Email: "ALE@EXAMPLE.COM"}
+	if err := c.Insert(ale); err != nil {
+		t.Fatal(err)
+	}
+	if !ale.inserted || ale.Email != "ale@example.com" {
+		t.Fatalf("insert hooks not called: %+v", ale)
+	}
+	if err := c.Insert(&hookedPerson{Email: "x"}); err == nil || srv.Received("insert") != 1 {
+		t.Fatalf("invalid document reached the server: %v", err)
+	}
+	if err := c.Update(bson.M{"name": "Ale"}, &hookedPerson{Name: "Ale", Email: "NEW@EXAMPLE.COM"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Update(bson.M{"name": "Ale"}, &hookedPerson{}); err == nil {
+		t.Fatal("invalid update accepted")
+	}
+
+	var one hookedPerson
+	if err := c.Find(nil).One(&one); err != nil || !one.Loaded || one.Email != "new@example.com" {
+		t.Fatalf("got %+v, %v", one, err)
+	}
+	var all []hookedPerson
+	if err := c.Find(nil).All(&all); err != nil || len(all) != 1 || !all[0].Loaded {
+		t.Fatalf("got %+v, %v", all, err)
+	}
+	iter := c.Find(nil).Iter()
+	var next hookedPerson
+	if !iter.Next(&next) || !next.Loaded {
+		t.Fatalf("got %+v, %v", next, iter.Err())
+	}
+	if err := iter.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,237 @@
+package mdb
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/globalsign/mgo"
+)
+
+// IndexesFor derives index definitions from the `mdb` struct tags of model,
+// which must be a struct or a pointer to one. Field names are taken from the
+// bson tags, and fields of nested structs are named with dots.
+//
+// The tag holds a comma separated list of directives:
+//
+//     index           the field is indexed
+//     index=<group>   the field is part of the compound index named group
+//     unique          like index, and the index is unique
+//     unique=<group>  like index=<group>, and the index is unique
+//     text            the field is part of a text index; text=<group> also works
+//     2dsphere        the field holds a GeoJSON value indexed for spherical queries
+//     ttl=<duration>  documents expire after duration, such as 24h, counted
+//                     from the time.Time value of the field
+//     desc            sort the field in descending order in its indexes
+//     sparse          only index documents which hold the field
+//     background      build the index in background
+//
+// Fields of a compound group appear in the index in struct order. For example:
+//
+//     type Person struct {
+//         Email     string    `bson:"email" mdb:"unique"`
+//         LastName  string    `bson:"lastName" mdb:"index=name"`
+//         FirstName string    `bson:"firstName" mdb:"index=name"`
+//         Session   time.Time `bson:"session" mdb:"ttl=24h"`
+//     }
+//
+func IndexesFor(model interface{}) ([]mgo.Index, error) {
+	t := reflect.TypeOf(model)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("mdb: IndexesFor needs a struct, got %T", model)
+	}
+	b := &indexBuilder{groups: map[string]*mgo.Index{}}
+	if err := b.scan(t, "", map[reflect.Type]bool{}); err != nil {
+		return nil, err
+	}
+	indexes := make([]mgo.Index, len(b.order))
+	for i, name := range b.order {
+		indexes[i] = *b.groups[name]
+	}
+	return indexes, nil
+}
+
+// EnsureIndexesFor ensures the indexes declared in the struct tags of model
+// exist on the collection. See IndexesFor for the tag format.
+func (c *Collection) EnsureIndexesFor(model interface{}) error {
+	indexes, err := IndexesFor(model)
+	if err != nil {
+		return err
+	}
+	for _, index := range indexes {
+		if err := c.EnsureIndex(index); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexBuilder collects the indexes of a model. Single field indexes are
+// keyed by "field " and the field path, compound indexes by "group " and
+// their name, so that the two never merge.
+type indexBuilder struct {
+	groups map[string]*mgo.Index
+	order  []string
+}
+
+func (b *indexBuilder) scan(t reflect.Type, prefix string, seen map[reflect.Type]bool) error {
+	if seen[t] {
+		return nil
+	}
+	seen[t] = true
+	defer delete(seen, t)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			continue
+		}
+		name, inline, skip := bsonFieldName(f)
+		if skip {
+			continue
+		}
+		ft := f.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if tag := f.Tag.Get("mdb"); tag != "" {
+			if err := b.add(prefix+name, tag); err != nil {
+				return fmt.Errorf("mdb: field %s.%s: %v", t.Name(), f.Name, err)
+			}
+		}
+		if ft.Kind() == reflect.Struct && ft != timeType {
+			next := prefix + name + "."
+			if inline {
+				next = prefix
+			}
+			if err := b.scan(ft, next, seen); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// bsonFieldName returns the name of f in documents as marshalled by bson.
+func bsonFieldName(f reflect.StructField) (name string, inline, skip bool) {
+	tag := bsonTag(f)
+	if tag == "-" {
+		return "", false, true
+	}
+	parts := strings.Split(tag, ",")
+	for _, flag := range parts[1:] {
+		if flag == "inline" {
+			inline = true
+		}
+	}
+	name = parts[0]
+	if name == "" {
+		name = strings.ToLower(f.Name)
+	}
+	return name, inline, false
+}
+
+// bsonTag returns the bson tag of f, which may also be given as the whole
+// struct tag.
+func bsonTag(f reflect.StructField) string {
+	tag := f.Tag.Get("bson")
+	if tag == "" && !strings.Contains(string(f.Tag), ":") {
+		tag = string(f.Tag)
+	}
+	return tag
+}
+
+// add records the index directives of a single field.
+func (b *indexBuilder) add(field, tag string) error {
+	var groups []string
+	var sparse, background, desc bool
+	unique := map[string]bool{}
+	var kind string
+	var ttl time.Duration
+	for _, directive := range strings.Split(tag, ",") {
+		key, value := directive, ""
+		if i := strings.Index(directive, "="); i >= 0 {
+			key, value = directive[:i], directive[i+1:]
+		}
+		switch key {
+		case "index", "unique", "text", "2dsphere":
+			if value == "" {
+				value = field
+			}
+			groups = append(groups, value)
+			unique[value] = unique[value] || key == "unique"
+			if key == "text" || key == "2dsphere" {
+				kind = key
+			}
+		case "ttl":
+			d, err := time.ParseDuration(value)
+			if err != nil || d < time.Second {
+				return fmt.Errorf("invalid ttl %q", value)
+			}
+			ttl = d
+			groups = append(groups, field)
+		case "desc":
+			desc = true
+		case "sparse":
+			sparse = true
+		case "background":
+			background = true
+		case "":
+		default:
+			return fmt.Errorf("unknown index directive %q", key)
+		}
+	}
+	key := field
+	switch {
+	case kind != "":
+		key = "$" + kind + ":" + field
+	case desc:
+		key = "-" + field
+	}
+	for i, group := range groups {
+		if containsString(groups[:i], group) {
+			continue
+		}
+		id, other := "group "+group, "field "+group
+		if group == field {
+			id, other = other, id
+		}
+		if _, ok := b.groups[other]; ok {
+			return fmt.Errorf("index group %s is named like an indexed field", group)
+		}
+		index, ok := b.groups[id]
+		if !ok {
+			index = &mgo.Index{}
+			if group != field {
+				index.Name = group
+			}
+			b.groups[id] = index
+			b.order = append(b.order, id)
+		}
+		index.Key = append(index.Key, key)
+		index.Unique = index.Unique || unique[group]
+		index.Sparse = index.Sparse || sparse
+		index.Background = index.Background || background
+		if ttl > 0 {
+			index.ExpireAfter = ttl
+		}
+		if index.ExpireAfter > 0 && len(index.Key) > 1 {
+			return fmt.Errorf("ttl index %s must have a single field", group)
+		}
+	}
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
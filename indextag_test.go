@@ -0,0 +1,76 @@
+package mdb
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo"
+)
+
+type indexedAddress struct {
+	City string `bson:"city" mdb:"index"`
+}
+
+type indexedPerson struct {
+	Email     string         `bson:"email" mdb:"unique,sparse"`
+	LastName  string         `bson:"lastName" mdb:"index=name"`
+	FirstName string         `bson:"firstName" mdb:"index=name,desc"`
+	Bio       string         `bson:"bio" mdb:"text"`
+	Session   time.Time      `bson:"session" mdb:"ttl=24h"`
+	Address   indexedAddress `bson:"address"`
+	Ignored   string         `bson:"-" mdb:"index"`
+}
+
+func TestIndexesFor(t *testing.T) {
+	got, err := IndexesFor(&indexedPerson{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []mgo.Index{
+		{Key: []string{"email"}, Unique: true, Sparse: true},
+		{Name: "name", Key: []string{"lastName", "-firstName"}},
+		{Key: []string{"$text:bio"}},
+		{Key: []string{"session"}, ExpireAfter: 24 * time.Hour},
+		{Key: []string{"address.city"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got  %+v\nwant %+v", got, want)
+	}
+
+	type bad struct {
+		A time.Time `mdb:"ttl=forever"`
+	}
+	if _, err := IndexesFor(bad{}); err == nil {
+		t.Fatal("invalid ttl accepted")
+	}
+	type clash struct {
+		Email string `bson:"email" mdb:"index"`
+		Name  string `bson:"name" mdb:"index=email"`
+	}
+	if _, err := IndexesFor(clash{}); err == nil {
+		t.Fatal("group named like an indexed field accepted")
+	}
+	type reversed struct {
+		Name  string `bson:"name" mdb:"unique=email"`
+		Email string `bson:"email" mdb:"index"`
+	}
+	if _, err := IndexesFor(reversed{}); err == nil {
+		t.Fatal("indexed field named like a group accepted")
+	}
+}
+
+func TestEnsureIndexesFor(t *testing.T) {
+	_, db := dialTest(t)
+	c := db.C("people")
+	if err := c.EnsureIndexesFor(indexedPerson{}); err != nil {
+		t.Fatal(err)
+	}
+	indexes, err := c.Indexes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(indexes) != 6 {
+		t.Fatalf("got %d indexes, want 6: %+v", len(indexes), indexes)
+	}
+}
@@ -11,8 +11,12 @@ import (
 //    https://docs.mongodb.com/manual/tutorial/iterate-a-cursor/
 //
 type Iter struct {
-	i  *mgo.Iter
-	db *Database
+	i   *mgo.Iter // nil when the cursor could not be opened, err holding why
+	db  *Database
+	col *Collection
+	err error // error returned by an AfterFind hook or injected by a FaultInjector
+
+	resume *resumeState // set for iterators made by Query.ResumableIter
 }
 
 // Err returns nil if no errors happened during iteration, or the actual
@@ -22,6 +26,12 @@ type Iter struct {
 // standard ways for MongoDB to report an improper query, the returned value has
 // a *QueryError type, and includes the Err message and the Code.
 func (iter *Iter) Err() (err error) {
+	if iter.err != nil {
+		return iter.err
+	}
+	if iter.i == nil {
+		return nil
+	}
 	return iter.i.Err()
 }
 
@@ -41,8 +51,16 @@ func (iter *Iter) Err() (err error) {
 // standard ways for MongoDB to report an improper query, the returned value has
 // a *QueryError type.
 func (iter *Iter) Close() (err error) {
+	if iter.i == nil {
+		return iter.err
+	}
 	for i := 0; i < iter.db.MaxConnectRetries; i++ {
-		err = iter.i.Close()
+		if err = iter.db.fault(iter.col.Name, OpIterClose); err == nil {
+			err = iter.i.Close()
+		}
+		if err == nil && iter.err != nil {
+			return iter.err
+		}
 		if !isNetworkError(err) {
 			return
 		}
@@ -61,14 +79,14 @@ func (iter *Iter) Close() (err error) {
 // Done may block waiting for a pending query to verify whether
 // more data is actually available or not.
 func (iter *Iter) Done() bool {
-	return iter.i.Done()
+	return iter.i == nil || iter.i.Done()
 }
 
 // Timeout returns true if Next returned false due to a timeout of
 // a tailable cursor. In those cases, Next may be called again to continue
 // the iteration at the previous cursor position.
 func (iter *Iter) Timeout() bool {
-	return iter.i.Timeout()
+	return iter.i != nil && iter.i.Timeout()
 }
 
 // Next retrieves the next document from the result set, blocking if necessary.
@@ -93,7 +111,22 @@ func (iter *Iter) Timeout() bool {
 //    }
 //
 func (iter *Iter) Next(result interface{}) bool {
-	return iter.i.Next(result)
+	if iter.resume != nil {
+		return iter.resumableNext(result)
+	}
+	if iter.err != nil || iter.i == nil {
+		return false
+	}
+	if iter.err = iter.db.fault(iter.col.Name, OpIterNext); iter.err != nil {
+		return false
+	}
+	if !iter.i.Next(result) {
+		return false
+	}
+	if iter.err = afterFind(result); iter.err != nil {
+		return false
+	}
+	return true
 }
 
 // All retrieves all documents from the result set into the provided slice
@@ -117,8 +150,19 @@ func (iter *Iter) Next(result interface{}) bool {
 //    }
 //
 func (iter *Iter) All(result interface{}) (err error) {
+	if iter.resume != nil {
+		return iter.resumableAll(result)
+	}
+	if iter.i == nil {
+		return iter.err
+	}
 	for i := 0; i < iter.db.MaxConnectRetries; i++ {
-		err = iter.i.All(result)
+		if err = iter.db.fault(iter.col.Name, OpIterAll); err == nil {
+			err = iter.i.All(result)
+		}
+		if err == nil {
+			return afterFind(result)
+		}
 		if !isNetworkError(err) {
 			return
 		}
@@ -15,15 +15,38 @@ type Collection struct {
 	Database *Database
 	Name     string
 	col      *mgo.Collection
+
+	timestamps      *TimestampOptions
+	softDelete      *softDelete
+	versionField    string
+	validateStructs bool
 }
 
 // Insert inserts one or more documents in the respective collection.  In
 // case the session is in safe mode (see the SetSafe method) and an error
 // happens while inserting the provided documents, the returned error will
 // be of type *LastError.
+//
+// Documents implementing BeforeInserter, Validator or AfterInserter have
+// those hooks called around the insertion.
 func (c *Collection) Insert(docs ...interface{}) (err error) {
+	if err = beforeInsert(docs); err != nil {
+		return err
+	}
+	if err = c.validate(docs...); err != nil {
+		return err
+	}
+	stamped, err := c.timestamps.stampInsert(docs)
+	if err != nil {
+		return err
+	}
 	for i := 0; i < c.Database.MaxConnectRetries; i++ {
-		err = c.col.Insert(docs...)
+		if err = c.Database.fault(c.Name, OpInsert); err == nil {
+			err = c.col.Insert(stamped...)
+		}
+		if err == nil {
+			return afterInsert(docs)
+		}
 		if !isNetworkError(err) {
 			return
 		}
@@ -34,8 +57,13 @@ func (c *Collection) Insert(docs ...interface{}) (err error) {
 
 // Count returns the total number of documents in the collection.
 func (c *Collection) Count() (n int, err error) {
+	if c.softDelete != nil {
+		return c.Find(nil).Count()
+	}
 	for i := 0; i < c.Database.MaxConnectRetries; i++ {
-		n, err = c.col.Count()
+		if err = c.Database.fault(c.Name, OpCount); err == nil {
+			n, err = c.col.Count()
+		}
 		if !isNetworkError(err) {
 			return
 		}
@@ -56,7 +84,9 @@ func (c *Collection) Count() (n int, err error) {
 //
 func (c *Collection) Create(info *mgo.CollectionInfo) (err error) {
 	for i := 0; i < c.Database.MaxConnectRetries; i++ {
-		err = c.col.Create(info)
+		if err = c.Database.fault(c.Name, OpCreate); err == nil {
+			err = c.col.Create(info)
+		}
 		if !isNetworkError(err) {
 			return
 		}
@@ -68,7 +98,9 @@ func (c *Collection) Create(info *mgo.CollectionInfo) (err error) {
 // DropCollection removes the entire collection including all of its documents.
 func (c *Collection) DropCollection() (err error) {
 	for i := 0; i < c.Database.MaxConnectRetries; i++ {
-		err = c.col.DropCollection()
+		if err = c.Database.fault(c.Name, OpDropCollection); err == nil {
+			err = c.col.DropCollection()
+		}
 		if !isNetworkError(err) {
 			return
 		}
@@ -85,7 +117,9 @@ func (c *Collection) DropCollection() (err error) {
 //
 func (c *Collection) DropIndexName(name string) (err error) {
 	for i := 0; i < c.Database.MaxConnectRetries; i++ {
-		err = c.col.DropIndexName(name)
+		if err = c.Database.fault(c.Name, OpDropIndex); err == nil {
+			err = c.col.DropIndexName(name)
+		}
 		if !isNetworkError(err) {
 			return
 		}
@@ -105,7 +139,9 @@ func (c *Collection) DropIndexName(name string) (err error) {
 //
 func (c *Collection) DropIndex(key ...string) (err error) {
 	for i := 0; i < c.Database.MaxConnectRetries; i++ {
-		err = c.col.DropIndex(key...)
+		if err = c.Database.fault(c.Name, OpDropIndex); err == nil {
+			err = c.col.DropIndex(key...)
+		}
 		if !isNetworkError(err) {
 			return
 		}
@@ -188,7 +224,9 @@ func (c *Collection) DropIndex(key ...string) (err error) {
 //
 func (c *Collection) EnsureIndex(index mgo.Index) (err error) {
 	for i := 0; i < c.Database.MaxConnectRetries; i++ {
-		err = c.col.EnsureIndex(index)
+		if err = c.Database.fault(c.Name, OpEnsureIndex); err == nil {
+			err = c.col.EnsureIndex(index)
+		}
 		if !isNetworkError(err) {
 			return
 		}
@@ -198,7 +236,8 @@ func (c *Collection) EnsureIndex(index mgo.Index) (err error) {
 }
 
 // Pipe prepares a pipeline to aggregate. The pipeline document
-// must be a slice built in terms of the aggregation framework language.
+// must be a slice built in terms of the aggregation framework language,
+// or a Pipeline.
 //
 // For example:
 //
@@ -212,8 +251,8 @@ func (c *Collection) EnsureIndex(index mgo.Index) (err error) {
 //     http://docs.mongodb.org/manual/tutorial/aggregation-examples
 //
 
-func (c *Collection) Pipe(Pipe interface{}) *mgo.Pipe {
-	return c.col.Pipe(Pipe)
+func (c *Collection) Pipe(pipeline interface{}) *Pipe {
+	return &Pipe{p: c.col.Pipe(pipeline), db: c.Database, col: c}
 }
 
 // Remove finds a single document matching the provided selector document
@@ -227,8 +266,13 @@ func (c *Collection) Pipe(Pipe interface{}) *mgo.Pipe {
 //     http://www.mongodb.org/display/DOCS/Removing
 //
 func (c *Collection) Remove(selector interface{}) (err error) {
+	if c.softDelete != nil {
+		return c.Update(c.softDelete.filter(selector, liveDocs), c.deletion())
+	}
 	for i := 0; i < c.Database.MaxConnectRetries; i++ {
-		err = c.col.Remove(selector)
+		if err = c.Database.fault(c.Name, OpRemove); err == nil {
+			err = c.col.Remove(selector)
+		}
 		if !isNetworkError(err) {
 			return
 		}
@@ -251,7 +295,9 @@ func (c *Collection) RemoveId(id interface{}) error {
 // See the EnsureIndex method for more details on indexes.
 func (c *Collection) Indexes() (indexes []mgo.Index, err error) {
 	for i := 0; i < c.Database.MaxConnectRetries; i++ {
-		indexes, err = c.col.Indexes()
+		if err = c.Database.fault(c.Name, OpIndexes); err == nil {
+			indexes, err = c.col.Indexes()
+		}
 		if !isNetworkError(err) {
 			return
 		}
@@ -270,8 +316,13 @@ func (c *Collection) Indexes() (indexes []mgo.Index, err error) {
 //     http://www.mongodb.org/display/DOCS/Removing
 //
 func (c *Collection) RemoveAll(selector interface{}) (info *mgo.ChangeInfo, err error) {
+	if c.softDelete != nil {
+		return c.UpdateAll(c.softDelete.filter(selector, liveDocs), c.deletion())
+	}
 	for i := 0; i < c.Database.MaxConnectRetries; i++ {
-		info, err = c.col.RemoveAll(selector)
+		if err = c.Database.fault(c.Name, OpRemoveAll); err == nil {
+			info, err = c.col.RemoveAll(selector)
+		}
 		if !isNetworkError(err) {
 			return
 		}
@@ -301,14 +352,41 @@ func (c *Collection) UpdateId(id interface{}, update interface{}) (err error) {
 //     http://www.mongodb.org/display/DOCS/Atomic+Operations
 //
 func (c *Collection) Update(id interface{}, update interface{}) (err error) {
+	_, err = c.update(id, update)
+	return err
+}
+
+// update implements Update, also reporting whether the update was sent
+// again after a network error, in which case it may have been applied by
+// an attempt whose reply was lost.
+func (c *Collection) update(id interface{}, update interface{}) (retried bool, err error) {
+	var arrayFilters []interface{}
+	if update, arrayFilters, err = splitUpdate(update); err != nil {
+		return false, err
+	}
+	if err = beforeUpdate(update); err != nil {
+		return false, err
+	}
+	if err = c.validate(update); err != nil {
+		return false, err
+	}
+	if update, err = c.timestamps.stampUpdate(update, false); err != nil {
+		return false, err
+	}
 	for i := 0; i < c.Database.MaxConnectRetries; i++ {
-		err = c.col.Update(id, update)
+		if err = c.Database.fault(c.Name, OpUpdate); err == nil {
+			if arrayFilters != nil {
+				_, err = c.runUpdate(id, update, arrayFilters, false, false)
+			} else {
+				err = c.col.Update(id, update)
+			}
+		}
 		if !isNetworkError(err) {
-			return
+			return i > 0, err
 		}
 		c.Database.refresh()
 	}
-	return err
+	return true, err
 }
 
 // UpdateAll finds all documents matching the provided selector document
@@ -324,8 +402,21 @@ func (c *Collection) Update(id interface{}, update interface{}) (err error) {
 //     http://www.mongodb.org/display/DOCS/Atomic+Operations
 //
 func (c *Collection) UpdateAll(selector interface{}, update interface{}) (info *mgo.ChangeInfo, err error) {
+	var arrayFilters []interface{}
+	if update, arrayFilters, err = splitUpdate(update); err != nil {
+		return nil, err
+	}
+	if update, err = c.timestamps.stampUpdate(update, false); err != nil {
+		return nil, err
+	}
 	for i := 0; i < c.Database.MaxConnectRetries; i++ {
-		info, err = c.col.UpdateAll(selector, update)
+		if err = c.Database.fault(c.Name, OpUpdateAll); err == nil {
+			if arrayFilters != nil {
+				info, err = c.runUpdate(selector, update, arrayFilters, true, false)
+			} else {
+				info, err = c.col.UpdateAll(selector, update)
+			}
+		}
 		if !isNetworkError(err) {
 			return
 		}
@@ -348,8 +439,27 @@ func (c *Collection) UpdateAll(selector interface{}, update interface{}) (info *
 //     http://www.mongodb.org/display/DOCS/Atomic+Operations
 //
 func (c *Collection) Upsert(selector interface{}, update interface{}) (info *mgo.ChangeInfo, err error) {
+	var arrayFilters []interface{}
+	if update, arrayFilters, err = splitUpdate(update); err != nil {
+		return nil, err
+	}
+	if err = beforeUpdate(update); err != nil {
+		return nil, err
+	}
+	if err = c.validate(update); err != nil {
+		return nil, err
+	}
+	if update, err = c.timestamps.stampUpdate(update, true); err != nil {
+		return nil, err
+	}
 	for i := 0; i < c.Database.MaxConnectRetries; i++ {
-		info, err = c.col.Upsert(selector, update)
+		if err = c.Database.fault(c.Name, OpUpsert); err == nil {
+			if arrayFilters != nil {
+				info, err = c.runUpdate(selector, update, arrayFilters, false, true)
+			} else {
+				info, err = c.col.Upsert(selector, update)
+			}
+		}
 		if !isNetworkError(err) {
 			return
 		}
@@ -364,8 +474,27 @@ func (c *Collection) Upsert(selector interface{}, update interface{}) (info *mgo
 //
 // See the Upsert method for more details.
 func (c *Collection) UpsertId(id interface{}, update interface{}) (info *mgo.ChangeInfo, err error) {
+	var arrayFilters []interface{}
+	if update, arrayFilters, err = splitUpdate(update); err != nil {
+		return nil, err
+	}
+	if err = beforeUpdate(update); err != nil {
+		return nil, err
+	}
+	if err = c.validate(update); err != nil {
+		return nil, err
+	}
+	if update, err = c.timestamps.stampUpdate(update, true); err != nil {
+		return nil, err
+	}
 	for i := 0; i < c.Database.MaxConnectRetries; i++ {
-		info, err = c.col.UpsertId(id, update)
+		if err = c.Database.fault(c.Name, OpUpsert); err == nil {
+			if arrayFilters != nil {
+				info, err = c.runUpdate(bson.D{{Name: "_id", Value: id}}, update, arrayFilters, false, true)
+			} else {
+				info, err = c.col.UpsertId(id, update)
+			}
+		}
 		if !isNetworkError(err) {
 			return
 		}
@@ -422,7 +551,10 @@ func (c *Collection) FindId(id interface{}) *Query {
 //     http://www.mongodb.org/display/DOCS/Advanced+Queries
 //
 func (c *Collection) Find(query interface{}) *Query {
-	return &Query{db: c.Database, q: c.col.Find(query)}
+	if c.softDelete != nil {
+		query = c.softDelete.filter(query, c.softDelete.scope)
+	}
+	return &Query{db: c.Database, col: c, q: c.col.Find(query), filter: query}
 }
 
 // NewIter returns a newly created iterator with the provided parameters. Using
@@ -451,10 +583,6 @@ func (c *Collection) Find(query interface{}) *Query {
 // server. Ensure the connection has been established (i.e. by calling
 // session.Ping()) before calling NewIter.
 func (c *Collection) NewIter(firstBatch []bson.Raw, cursorId int64, err error) *Iter {
-	return &Iter{i: c.col.NewIter(c.Database.session, firstBatch, cursorId, err), db: c.Database}
+	return &Iter{i: c.col.NewIter(c.Database.session, firstBatch, cursorId, err), db: c.Database, col: c}
 }
 
-// Bulk returns a value to prepare the execution of a bulk operation.
-func (c *Collection) Bulk() *mgo.Bulk {
-	return c.col.Bulk()
-}
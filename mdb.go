@@ -9,6 +9,7 @@ import (
 	"net/url"
 	"errors"
 	"strconv"
+	"sync/atomic"
 )
 
 
@@ -103,11 +104,13 @@ type Database struct {
 	Name    string
 	MaxConnectRetries int
 	session *mgo.Session
-	refreshing bool
+	refreshing int32 // set while refresh runs, which may be concurrent
+	faults *FaultInjector
+	pageKey []byte
 }
 
 func (db *Database) DB(name string) *Database {
-	return &Database{session:db.session, Name:name, MaxConnectRetries: db.MaxConnectRetries,}
+	return &Database{session:db.session, Name:name, MaxConnectRetries: db.MaxConnectRetries, faults: db.faults, pageKey: db.pageKey,}
 }
 
 func (db *Database) Close(){
@@ -128,15 +131,25 @@ func (db *Database) BuildInfo() (info mgo.BuildInfo, err error) {
 }
 
 func (db *Database) Clone() *Database {
-	return &Database{session:db.session.Clone(), Name:db.Name}
+	return &Database{session:db.session.Clone(), Name:db.Name, MaxConnectRetries: db.MaxConnectRetries, faults: db.faults, pageKey: db.pageKey}
 }
 
 func (db *Database) Copy() *Database {
-	return &Database{session:db.session.Copy(), Name:db.Name}
+	return &Database{session:db.session.Copy(), Name:db.Name, MaxConnectRetries: db.MaxConnectRetries, faults: db.faults, pageKey: db.pageKey}
+}
+
+// SetFaultInjector makes operations on db, and on the collections, queries and
+// iterators derived from it, consult faults before reaching the server.
+// A nil injector disables fault injection.
+func (db *Database) SetFaultInjector(faults *FaultInjector) {
+	db.faults = faults
 }
 
 func (db *Database) Run(cmd interface{}, result interface{}) error {
-	err := db.session.DB(db.Name).Run(cmd, result)
+	err := db.fault("", OpRun)
+	if err == nil {
+		err = db.session.DB(db.Name).Run(cmd, result)
+	}
 	if err != nil && isNetworkError(err){
 		db.session.Refresh()
 		return db.session.DB(db.Name).Run(cmd, result)
@@ -145,12 +158,19 @@ func (db *Database) Run(cmd interface{}, result interface{}) error {
 }
 
 
+// fault returns the error injected into op on the named collection, if any.
+func (db *Database) fault(collection string, op Op) error {
+	if db.faults == nil {
+		return nil
+	}
+	return db.faults.check(collection, op)
+}
+
 func (db *Database) refresh() {
-	if db.refreshing {
+	if !atomic.CompareAndSwapInt32(&db.refreshing, 0, 1) {
 		time.Sleep(time.Second)
 		return
 	}
-	db.refreshing = true
 	db.session.Refresh()
-	db.refreshing = false
+	atomic.StoreInt32(&db.refreshing, 0)
 }
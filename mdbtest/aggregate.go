@@ -0,0 +1,539 @@
+package mdbtest
+
+import (
+	"math/rand"
+	"strings"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// aggregate runs the aggregate command. The supported stages are $match,
+// $sort, $skip, $limit, $sample, $project, $addFields, $unwind, $group,
+// $bucket, $count, $lookup, $facet and $out, with field paths, literals and
+// a few arithmetic and string operators as expressions.
+func (s *Server) aggregate(db string, cmd bson.D) (bson.D, error) {
+	name := stringArg(cmd, "aggregate")
+	stages, _ := first(lookup(cmd, "pipeline")).([]interface{})
+	if truthy(first(lookup(cmd, "explain"))) {
+		return bson.D{{Name: "stages", Value: stages}}, nil
+	}
+	s.store.mu.Lock()
+	defer s.store.mu.Unlock()
+	var docs []bson.D
+	if c := s.store.collection(db, name, false); c != nil {
+		for _, doc := range c.docs {
+			docs = append(docs, copyDoc(doc))
+		}
+	}
+	docs, err := s.runPipeline(db, docs, stages)
+	if err != nil {
+		return nil, err
+	}
+	size := intArg(docArg(cmd, "cursor"), "batchSize")
+	return s.cursorReply(db+"."+name, docs, size, false, "firstBatch"), nil
+}
+
+// runPipeline runs stages on docs. The store lock must be held.
+func (s *Server) runPipeline(db string, docs []bson.D, stages []interface{}) ([]bson.D, error) {
+	for _, item := range stages {
+		stage, ok := asDoc(item)
+		if !ok || len(stage) != 1 {
+			return nil, &queryError{Code: 40323, Message: "A pipeline stage specification object must contain exactly one field."}
+		}
+		op, arg := stage[0].Name, stage[0].Value
+		var err error
+		switch op {
+		case "$match":
+			filter, _ := asDoc(arg)
+			var out []bson.D
+			for _, doc := range docs {
+				ok, err := match(doc, filter)
+				if err != nil {
+					return nil, err
+				}
+				if ok {
+					out = append(out, doc)
+				}
+			}
+			docs = out
+		case "$sort":
+			spec, _ := asDoc(arg)
+			sortDocs(docs, spec)
+		case "$skip":
+			n, _ := toFloat(arg)
+			docs = window(docs, int(n), 0)
+		case "$limit":
+			n, _ := toFloat(arg)
+			docs = window(docs, 0, int(n))
+		case "$project":
+			spec, _ := asDoc(arg)
+			docs, err = mapDocs(docs, func(doc bson.D) (bson.D, error) { return aggregateProject(doc, spec) })
+		case "$addFields":
+			spec, _ := asDoc(arg)
+			docs, err = mapDocs(docs, func(doc bson.D) (bson.D, error) { return addFields(doc, spec) })
+		case "$unwind":
+			docs, err = unwind(docs, arg)
+		case "$group":
+			spec, _ := asDoc(arg)
+			docs, err = group(docs, spec)
+		case "$bucket":
+			spec, _ := asDoc(arg)
+			docs, err = bucket(docs, spec)
+		case "$sample":
+			spec, _ := asDoc(arg)
+			n := intArg(spec, "size")
+			rand.Shuffle(len(docs), func(i, j int) { docs[i], docs[j] = docs[j], docs[i] })
+			if n < len(docs) {
+				docs = docs[:n]
+			}
+		case "$count":
+			field, _ := arg.(string)
+			if len(docs) > 0 {
+				docs = []bson.D{{{Name: field, Value: len(docs)}}}
+			}
+		case "$lookup":
+			spec, _ := asDoc(arg)
+			docs, err = s.lookupStage(db, docs, spec)
+		case "$facet":
+			spec, _ := asDoc(arg)
+			result := bson.D{}
+			for _, facet := range spec {
+				sub, _ := facet.Value.([]interface{})
+				input := make([]bson.D, len(docs))
+				for i, doc := range docs {
+					input[i] = copyDoc(doc)
+				}
+				out, err := s.runPipeline(db, input, sub)
+				if err != nil {
+					return nil, err
+				}
+				list := make([]interface{}, len(out))
+				for i, doc := range out {
+					list[i] = doc
+				}
+				result = append(result, bson.DocElem{Name: facet.Name, Value: list})
+			}
+			docs = []bson.D{result}
+		case "$out":
+			name, _ := arg.(string)
+			c := s.store.collection(db, name, true)
+			c.docs = nil
+			for _, doc := range docs {
+				if len(lookup(doc, "_id")) == 0 {
+					doc = append(bson.D{{Name: "_id", Value: bson.NewObjectId()}}, doc...)
+				}
+				c.docs = append(c.docs, doc)
+			}
+			docs = nil
+		default:
+			return nil, &queryError{Code: 40324, Message: "Unrecognized pipeline stage name: '" + op + "'"}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return docs, nil
+}
+
+func mapDocs(docs []bson.D, f func(bson.D) (bson.D, error)) ([]bson.D, error) {
+	out := make([]bson.D, len(docs))
+	for i, doc := range docs {
+		var err error
+		if out[i], err = f(doc); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// aggregateProject applies a $project stage, whose fields may also be
+// computed by expressions.
+func aggregateProject(doc, spec bson.D) (bson.D, error) {
+	var plain, computed bson.D
+	for _, e := range spec {
+		switch e.Value.(type) {
+		case bool, int, int32, int64, float64:
+			plain = append(plain, e)
+		default:
+			computed = append(computed, e)
+		}
+	}
+	out := bson.D{}
+	if len(plain) > 0 {
+		out = project(doc, plain)
+	} else if id := lookup(doc, "_id"); len(id) > 0 {
+		out = bson.D{{Name: "_id", Value: id[0]}}
+	}
+	for _, e := range computed {
+		v, err := evalExpr(doc, e.Value)
+		if err != nil {
+			return nil, err
+		}
+		if out, err = setPath(out, e.Name, v); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+func addFields(doc, spec bson.D) (bson.D, error) {
+	out := doc
+	for _, e := range spec {
+		v, err := evalExpr(doc, e.Value)
+		if err != nil {
+			return nil, err
+		}
+		if out, err = setPath(out, e.Name, v); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+func unwind(docs []bson.D, arg interface{}) ([]bson.D, error) {
+	path, _ := arg.(string)
+	preserve := false
+	if spec, ok := asDoc(arg); ok {
+		path = stringArg(spec, "path")
+		preserve = truthy(first(lookup(spec, "preserveNullAndEmptyArrays")))
+	}
+	if !strings.HasPrefix(path, "$") {
+		return nil, badValue("$unwind path must start with $")
+	}
+	path = path[1:]
+	var out []bson.D
+	for _, doc := range docs {
+		v := first(lookup(doc, path))
+		list, isList := v.([]interface{})
+		switch {
+		case isList && len(list) > 0:
+			for _, item := range list {
+				unwound, err := setPath(copyDoc(doc), path, copyValue(item))
+				if err != nil {
+					return nil, err
+				}
+				out = append(out, unwound)
+			}
+		case !isList && v != nil:
+			out = append(out, doc)
+		case preserve:
+			out = append(out, doc)
+		}
+	}
+	return out, nil
+}
+
+// groupState accumulates the documents of a $group or $bucket group.
+type groupState struct {
+	id     interface{}
+	fields bson.D
+	counts map[string]int
+}
+
+func group(docs []bson.D, spec bson.D) ([]bson.D, error) {
+	idExpr := first(lookup(spec, "_id"))
+	var groups []*groupState
+	for _, doc := range docs {
+		id, err := evalExpr(doc, idExpr)
+		if err != nil {
+			return nil, err
+		}
+		g := findGroup(&groups, id)
+		if err := g.add(doc, spec); err != nil {
+			return nil, err
+		}
+	}
+	return groupDocs(groups), nil
+}
+
+func bucket(docs []bson.D, spec bson.D) ([]bson.D, error) {
+	boundaries, _ := first(lookup(spec, "boundaries")).([]interface{})
+	def := lookup(spec, "default")
+	output := docArg(spec, "output")
+	if output == nil {
+		output = bson.D{{Name: "count", Value: bson.D{{Name: "$sum", Value: 1}}}}
+	}
+	var groups []*groupState
+	for _, b := range boundaries[:len(boundaries)-1] {
+		findGroup(&groups, b)
+	}
+	for _, doc := range docs {
+		v, err := evalExpr(doc, first(lookup(spec, "groupBy")))
+		if err != nil {
+			return nil, err
+		}
+		var id interface{}
+		found := false
+		for i := 0; i+1 < len(boundaries); i++ {
+			if compare(v, boundaries[i]) >= 0 && compare(v, boundaries[i+1]) < 0 {
+				id, found = boundaries[i], true
+				break
+			}
+		}
+		if !found {
+			if len(def) == 0 {
+				return nil, &queryError{Code: 40066, Message: "$switch could not find a matching branch for an input, and no default was specified."}
+			}
+			id = def[0]
+		}
+		if err := findGroup(&groups, id).add(doc, output); err != nil {
+			return nil, err
+		}
+	}
+	var out []*groupState
+	for _, g := range groups {
+		if len(g.counts) > 0 {
+			out = append(out, g)
+		}
+	}
+	return groupDocs(out), nil
+}
+
+func findGroup(groups *[]*groupState, id interface{}) *groupState {
+	for _, g := range *groups {
+		if compare(g.id, id) == 0 {
+			return g
+		}
+	}
+	g := &groupState{id: id, counts: map[string]int{}}
+	*groups = append(*groups, g)
+	return g
+}
+
+// add accumulates doc into the fields of spec other than _id.
+func (g *groupState) add(doc bson.D, spec bson.D) error {
+	for _, e := range spec {
+		if e.Name == "_id" {
+			continue
+		}
+		acc, ok := asDoc(e.Value)
+		if !ok || len(acc) != 1 {
+			return &queryError{Code: 40234, Message: "The field '" + e.Name + "' must be an accumulator object"}
+		}
+		v, err := evalExpr(doc, acc[0].Value)
+		if err != nil {
+			return err
+		}
+		n := g.counts[e.Name]
+		g.counts[e.Name] = n + 1
+		old := first(lookup(g.fields, e.Name))
+		var next interface{}
+		switch acc[0].Name {
+		case "$sum", "$avg":
+			if old == nil {
+				old = 0
+			}
+			next = old
+			if _, isNum := toFloat(v); isNum {
+				if next, err = arithmetic("$inc", old, v); err != nil {
+					return err
+				}
+			}
+		case "$min", "$max":
+			next = old
+			if v != nil && (n == 0 || old == nil ||
+				acc[0].Name == "$min" && compare(v, old) < 0 || acc[0].Name == "$max" && compare(v, old) > 0) {
+				next = v
+			}
+		case "$first":
+			next = old
+			if n == 0 {
+				next = v
+			}
+		case "$last":
+			next = v
+		case "$push", "$addToSet":
+			list, _ := old.([]interface{})
+			if acc[0].Name == "$push" || !anyEqual(list, v) {
+				list = append(list, v)
+			}
+			next = list
+		default:
+			return &queryError{Code: 15952, Message: "unknown group operator '" + acc[0].Name + "'"}
+		}
+		g.fields = setField(g.fields, e.Name, next)
+		if acc[0].Name == "$avg" {
+			g.fields = setField(g.fields, "\x00avg."+e.Name, true)
+		}
+	}
+	return nil
+}
+
+func groupDocs(groups []*groupState) []bson.D {
+	out := make([]bson.D, 0, len(groups))
+	for _, g := range groups {
+		doc := bson.D{{Name: "_id", Value: g.id}}
+		for _, e := range g.fields {
+			if strings.HasPrefix(e.Name, "\x00") {
+				continue
+			}
+			if len(lookup(g.fields, "\x00avg."+e.Name)) > 0 {
+				sum, _ := toFloat(e.Value)
+				e.Value = sum / float64(g.counts[e.Name])
+			}
+			doc = append(doc, e)
+		}
+		out = append(out, doc)
+	}
+	return out
+}
+
+func (s *Server) lookupStage(db string, docs []bson.D, spec bson.D) ([]bson.D, error) {
+	var foreign []bson.D
+	if c := s.store.collection(db, stringArg(spec, "from"), false); c != nil {
+		foreign = c.docs
+	}
+	local, field, as := stringArg(spec, "localField"), stringArg(spec, "foreignField"), stringArg(spec, "as")
+	out := make([]bson.D, len(docs))
+	for i, doc := range docs {
+		values := expand(lookup(doc, local))
+		filter := bson.D{{Name: field, Value: nil}}
+		if len(values) > 0 {
+			filter[0].Value = bson.D{{Name: "$in", Value: values}}
+		}
+		joined := []interface{}{}
+		for _, f := range foreign {
+			ok, err := match(f, filter)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				joined = append(joined, copyDoc(f))
+			}
+		}
+		var err error
+		if out[i], err = setPath(doc, as, joined); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// evalExpr evaluates an aggregation expression against doc.
+func evalExpr(doc bson.D, expr interface{}) (interface{}, error) {
+	switch e := expr.(type) {
+	case string:
+		switch {
+		case e == "$$ROOT":
+			return doc, nil
+		case strings.HasPrefix(e, "$"):
+			values := lookup(doc, e[1:])
+			if len(values) > 1 {
+				return values, nil
+			}
+			return first(values), nil
+		}
+		return e, nil
+	case []interface{}:
+		out := make([]interface{}, len(e))
+		for i, item := range e {
+			v, err := evalExpr(doc, item)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = v
+		}
+		return out, nil
+	}
+	d, ok := asDoc(expr)
+	if !ok {
+		return expr, nil
+	}
+	if len(d) == 1 && strings.HasPrefix(d[0].Name, "$") {
+		return evalOperator(doc, d[0].Name, d[0].Value)
+	}
+	out := bson.D{}
+	for _, e := range d {
+		v, err := evalExpr(doc, e.Value)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, bson.DocElem{Name: e.Name, Value: v})
+	}
+	return out, nil
+}
+
+func evalOperator(doc bson.D, op string, arg interface{}) (interface{}, error) {
+	if op == "$literal" {
+		return arg, nil
+	}
+	v, err := evalExpr(doc, arg)
+	if err != nil {
+		return nil, err
+	}
+	args, isList := v.([]interface{})
+	if !isList {
+		args = []interface{}{v}
+	}
+	switch op {
+	case "$add", "$multiply":
+		var total interface{} = 0
+		if op == "$multiply" {
+			total = 1
+		}
+		for _, a := range args {
+			if a == nil {
+				return nil, nil
+			}
+			name := "$inc"
+			if op == "$multiply" {
+				name = "$mul"
+			}
+			if total, err = arithmetic(name, total, a); err != nil {
+				return nil, err
+			}
+		}
+		return total, nil
+	case "$subtract", "$divide":
+		if len(args) != 2 {
+			return nil, badValue("%s takes exactly 2 arguments", op)
+		}
+		a, aNum := toFloat(args[0])
+		b, bNum := toFloat(args[1])
+		if !aNum || !bNum {
+			return nil, nil
+		}
+		if op == "$subtract" {
+			if _, aInt := asInt(args[0]); aInt {
+				if _, bInt := asInt(args[1]); bInt {
+					return arithmetic("$inc", args[0], -int(b))
+				}
+			}
+			return a - b, nil
+		}
+		if b == 0 {
+			return nil, badValue("can't $divide by zero")
+		}
+		return a / b, nil
+	case "$concat":
+		var b strings.Builder
+		for _, a := range args {
+			s, ok := a.(string)
+			if !ok {
+				return nil, nil
+			}
+			b.WriteString(s)
+		}
+		return b.String(), nil
+	case "$toLower", "$toUpper":
+		s, _ := first(args).(string)
+		if op == "$toLower" {
+			return strings.ToLower(s), nil
+		}
+		return strings.ToUpper(s), nil
+	case "$size":
+		list, ok := first(args).([]interface{})
+		if !ok {
+			return nil, badValue("The argument to $size must be an array")
+		}
+		return len(list), nil
+	case "$ifNull":
+		for _, a := range args {
+			if a != nil {
+				return a, nil
+			}
+		}
+		return nil, nil
+	}
+	return nil, &queryError{Code: 168, Message: "Unrecognized expression '" + op + "'"}
+}
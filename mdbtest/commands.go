@@ -0,0 +1,582 @@
+package mdbtest
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// defaultBatchSize is the number of documents returned in the first batch
+// of a cursor when the client does not ask for a batch size.
+const defaultBatchSize = 101
+
+// command runs a database command and returns its reply document, or nil if
+// the connection must be dropped instead.
+func (s *Server) command(db string, cmd bson.D) interface{} {
+	if len(cmd) == 0 {
+		return errorDoc(badValue("empty command"))
+	}
+	name := cmd[0].Name
+	drop := s.shouldDrop(name)
+	if drop != nil && !drop.after {
+		return nil
+	}
+	var reply bson.D
+	var err error
+	switch strings.ToLower(name) {
+	case "ismaster":
+		reply = bson.D{
+			{Name: "ismaster", Value: true},
+			{Name: "maxBsonObjectSize", Value: 16 * 1024 * 1024},
+			{Name: "maxMessageSizeBytes", Value: 48000000},
+			{Name: "maxWriteBatchSize", Value: 100000},
+			{Name: "localTime", Value: time.Now()},
+			{Name: "maxWireVersion", Value: 6},
+			{Name: "minWireVersion", Value: 0},
+		}
+	case "getnonce":
+		reply = bson.D{{Name: "nonce", Value: fmt.Sprintf("%x", time.Now().UnixNano())}}
+	case "ping", "getlasterror", "endsessions":
+	case "buildinfo":
+		reply = bson.D{
+			{Name: "version", Value: "3.6.0"},
+			{Name: "versionArray", Value: []int{3, 6, 0, 0}},
+			{Name: "gitVersion", Value: "mdbtest"},
+			{Name: "bits", Value: 64},
+			{Name: "maxBsonObjectSize", Value: 16 * 1024 * 1024},
+		}
+	case "find":
+		reply, err = s.find(db, cmd)
+	case "getmore":
+		reply, err = s.getMore(cmd)
+	case "killcursors":
+		list, _ := first(lookup(cmd, "cursors")).([]interface{})
+		var killed []interface{}
+		for _, id := range list {
+			if n, ok := asInt(id); ok {
+				s.killCursor(n)
+				killed = append(killed, n)
+			}
+		}
+		reply = bson.D{{Name: "cursorsKilled", Value: killed}}
+	case "count":
+		reply, err = s.count(db, cmd)
+	case "distinct":
+		reply, err = s.distinct(db, cmd)
+	case "insert":
+		reply, err = s.insert(db, cmd)
+	case "update":
+		reply, err = s.update(db, cmd)
+	case "delete":
+		reply, err = s.delete(db, cmd)
+	case "findandmodify":
+		reply, err = s.findAndModify(db, cmd)
+	case "aggregate":
+		reply, err = s.aggregate(db, cmd)
+	case "splitvector":
+		reply, err = s.splitVector(cmd)
+	case "create":
+		err = s.create(db, cmd)
+	case "collmod":
+		err = s.collMod(db, cmd)
+	case "drop":
+		s.store.Drop(db, stringArg(cmd, name))
+	case "dropdatabase":
+		s.store.mu.Lock()
+		delete(s.store.dbs, db)
+		s.store.mu.Unlock()
+	case "listcollections":
+		var docs []bson.D
+		docs, err = s.listCollections(db, cmd)
+		reply = s.cursorReply(db+".$cmd.listCollections", docs, 0, false, "firstBatch")
+	case "createindexes":
+		reply, err = s.createIndexes(db, cmd)
+	case "listindexes":
+		reply, err = s.listIndexes(db, cmd)
+	case "dropindexes", "deleteindexes":
+		reply, err = s.dropIndexes(db, cmd)
+	default:
+		err = &queryError{Code: 59, Message: fmt.Sprintf("no such command: '%s'", name)}
+	}
+	if drop != nil {
+		return nil
+	}
+	if err != nil {
+		return errorDoc(err)
+	}
+	return append(reply, bson.DocElem{Name: "ok", Value: 1})
+}
+
+func errorDoc(err error) bson.D {
+	code := 8
+	if qerr, ok := err.(*queryError); ok {
+		code = qerr.Code
+	}
+	return bson.D{
+		{Name: "ok", Value: 0},
+		{Name: "errmsg", Value: err.Error()},
+		{Name: "code", Value: code},
+	}
+}
+
+func (s *Server) find(db string, cmd bson.D) (bson.D, error) {
+	name := stringArg(cmd, "find")
+	filter := docArg(cmd, "filter")
+	s.store.mu.Lock()
+	docs, err := s.store.find(db, name, filter)
+	for i, doc := range docs {
+		docs[i] = copyDoc(doc)
+	}
+	s.store.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	sortDocs(docs, docArg(cmd, "sort"))
+	docs = window(docs, intArg(cmd, "skip"), intArg(cmd, "limit"))
+	projection := docArg(cmd, "projection")
+	for i, doc := range docs {
+		docs[i] = project(doc, projection)
+	}
+	single := truthy(first(lookup(cmd, "singleBatch")))
+	return s.cursorReply(db+"."+name, docs, intArg(cmd, "batchSize"), single, "firstBatch"), nil
+}
+
+func (s *Server) getMore(cmd bson.D) (bson.D, error) {
+	id, _ := asInt(first(lookup(cmd, "getMore")))
+	s.mu.Lock()
+	c := s.cursors[id]
+	s.mu.Unlock()
+	if c == nil {
+		return nil, &queryError{Code: 43, Message: fmt.Sprintf("cursor id %d not found", id)}
+	}
+	size := intArg(cmd, "batchSize")
+	if size <= 0 {
+		size = len(c.docs)
+	}
+	s.mu.Lock()
+	if size > len(c.docs) {
+		size = len(c.docs)
+	}
+	batch := c.docs[:size]
+	c.docs = c.docs[size:]
+	if len(c.docs) == 0 {
+		delete(s.cursors, id)
+		id = 0
+	}
+	s.mu.Unlock()
+	return bson.D{{Name: "cursor", Value: bson.D{
+		{Name: "nextBatch", Value: batch},
+		{Name: "id", Value: id},
+		{Name: "ns", Value: c.ns},
+	}}}, nil
+}
+
+// cursorReply returns the first batch of docs, keeping the rest in a new
+// cursor unless single is set.
+func (s *Server) cursorReply(ns string, docs []bson.D, batchSize int, single bool, field string) bson.D {
+	if batchSize <= 0 {
+		batchSize = defaultBatchSize
+	}
+	var id int64
+	if batchSize < len(docs) {
+		if !single {
+			id = s.newCursor(ns, docs[batchSize:])
+		}
+		docs = docs[:batchSize]
+	}
+	if docs == nil {
+		docs = []bson.D{}
+	}
+	return bson.D{{Name: "cursor", Value: bson.D{
+		{Name: field, Value: docs},
+		{Name: "id", Value: id},
+		{Name: "ns", Value: ns},
+	}}}
+}
+
+// splitVector splits a collection by _id into chunks of maxChunkObjects
+// documents; the chunk size in bytes is ignored.
+func (s *Server) splitVector(cmd bson.D) (bson.D, error) {
+	db, name := splitNS(stringArg(cmd, "splitVector"))
+	size := intArg(cmd, "maxChunkObjects")
+	s.store.mu.Lock()
+	docs, err := s.store.find(db, name, nil)
+	s.store.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	sortDocs(docs, bson.D{{Name: "_id", Value: 1}})
+	keys := []interface{}{}
+	for i := size; size > 0 && i < len(docs); i += size {
+		keys = append(keys, bson.D{{Name: "_id", Value: first(lookup(docs[i], "_id"))}})
+	}
+	return bson.D{{Name: "splitKeys", Value: keys}}, nil
+}
+
+func (s *Server) count(db string, cmd bson.D) (bson.D, error) {
+	s.store.mu.Lock()
+	docs, err := s.store.find(db, stringArg(cmd, "count"), docArg(cmd, "query"))
+	s.store.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	docs = window(docs, intArg(cmd, "skip"), intArg(cmd, "limit"))
+	return bson.D{{Name: "n", Value: len(docs)}}, nil
+}
+
+func (s *Server) distinct(db string, cmd bson.D) (bson.D, error) {
+	s.store.mu.Lock()
+	docs, err := s.store.find(db, stringArg(cmd, "distinct"), docArg(cmd, "query"))
+	s.store.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	values := []interface{}{}
+	for _, doc := range docs {
+		for _, v := range expand(lookup(doc, stringArg(cmd, "key"))) {
+			if _, isList := v.([]interface{}); !isList && !anyEqual(values, v) {
+				values = append(values, copyValue(v))
+			}
+		}
+	}
+	return bson.D{{Name: "values", Value: values}}, nil
+}
+
+func (s *Server) insert(db string, cmd bson.D) (bson.D, error) {
+	list, _ := first(lookup(cmd, "documents")).([]interface{})
+	docs := make([]bson.D, 0, len(list))
+	for _, item := range list {
+		doc, ok := asDoc(item)
+		if !ok {
+			return nil, badValue("documents must be objects")
+		}
+		docs = append(docs, doc)
+	}
+	s.store.mu.Lock()
+	n, errs := s.store.insert(db, stringArg(cmd, "insert"), docs, orderedArg(cmd))
+	s.store.mu.Unlock()
+	return writeReply(n, -1, nil, errs), nil
+}
+
+func (s *Server) update(db string, cmd bson.D) (bson.D, error) {
+	name := stringArg(cmd, "update")
+	list, _ := first(lookup(cmd, "updates")).([]interface{})
+	ordered := orderedArg(cmd)
+	var n, modified int
+	var upserted []interface{}
+	errs := map[int]error{}
+	s.store.mu.Lock()
+	defer s.store.mu.Unlock()
+	for i, item := range list {
+		op, _ := asDoc(item)
+		arrayFilters, _ := first(lookup(op, "arrayFilters")).([]interface{})
+		matched, changed, id, err := s.store.update(db, name, docArg(op, "q"), docArg(op, "u"), arrayFilters,
+			truthy(first(lookup(op, "multi"))), truthy(first(lookup(op, "upsert"))))
+		if err != nil {
+			errs[i] = err
+			if ordered {
+				break
+			}
+			continue
+		}
+		n += matched
+		modified += changed
+		if id != nil {
+			n++
+			upserted = append(upserted, bson.D{{Name: "index", Value: i}, {Name: "_id", Value: id}})
+		}
+	}
+	return writeReply(n, modified, upserted, errs), nil
+}
+
+func (s *Server) delete(db string, cmd bson.D) (bson.D, error) {
+	name := stringArg(cmd, "delete")
+	list, _ := first(lookup(cmd, "deletes")).([]interface{})
+	ordered := orderedArg(cmd)
+	var n int
+	errs := map[int]error{}
+	s.store.mu.Lock()
+	defer s.store.mu.Unlock()
+	for i, item := range list {
+		op, _ := asDoc(item)
+		removed, err := s.store.remove(db, name, docArg(op, "q"), intArg(op, "limit"))
+		if err != nil {
+			errs[i] = err
+			if ordered {
+				break
+			}
+			continue
+		}
+		n += removed
+	}
+	return writeReply(n, -1, nil, errs), nil
+}
+
+// writeReply builds the reply of a write command. A negative modified count
+// is left out of the reply.
+func writeReply(n, modified int, upserted []interface{}, errs map[int]error) bson.D {
+	reply := bson.D{{Name: "n", Value: n}}
+	if modified >= 0 {
+		reply = append(reply, bson.DocElem{Name: "nModified", Value: modified})
+	}
+	if len(upserted) > 0 {
+		reply = append(reply, bson.DocElem{Name: "upserted", Value: upserted})
+	}
+	if len(errs) > 0 {
+		var list []interface{}
+		for i := 0; len(list) < len(errs); i++ {
+			if err, ok := errs[i]; ok {
+				e := errorDoc(err)
+				list = append(list, bson.D{{Name: "index", Value: i}, {Name: "code", Value: e[2].Value}, {Name: "errmsg", Value: e[1].Value}})
+			}
+		}
+		reply = append(reply, bson.DocElem{Name: "writeErrors", Value: list})
+	}
+	return reply
+}
+
+func (s *Server) findAndModify(db string, cmd bson.D) (bson.D, error) {
+	name := stringArg(cmd, cmd[0].Name)
+	s.store.mu.Lock()
+	defer s.store.mu.Unlock()
+	docs, err := s.store.find(db, name, docArg(cmd, "query"))
+	if err != nil {
+		return nil, err
+	}
+	docs = append([]bson.D(nil), docs...)
+	sortDocs(docs, docArg(cmd, "sort"))
+	fields := docArg(cmd, "fields")
+	update := docArg(cmd, "update")
+	arrayFilters, _ := first(lookup(cmd, "arrayFilters")).([]interface{})
+	returnNew := truthy(first(lookup(cmd, "new")))
+	lastError := bson.D{{Name: "n", Value: 0}, {Name: "updatedExisting", Value: false}}
+	var value interface{}
+	switch {
+	case len(docs) > 0 && truthy(first(lookup(cmd, "remove"))):
+		id := bson.D{{Name: "_id", Value: first(lookup(docs[0], "_id"))}}
+		if _, err := s.store.remove(db, name, id, 1); err != nil {
+			return nil, err
+		}
+		value = project(docs[0], fields)
+		lastError[0].Value = 1
+	case len(docs) > 0:
+		old := copyDoc(docs[0])
+		id := bson.D{{Name: "_id", Value: first(lookup(old, "_id"))}}
+		// The query is kept to resolve the $ positional operator.
+		filter := id
+		if query := docArg(cmd, "query"); len(query) > 0 {
+			filter = bson.D{{Name: "$and", Value: []interface{}{id, query}}}
+		}
+		if _, _, _, err := s.store.update(db, name, filter, update, arrayFilters, false, false); err != nil {
+			return nil, err
+		}
+		value = project(old, fields)
+		if returnNew {
+			updated, _ := s.store.find(db, name, id)
+			if len(updated) > 0 {
+				value = project(copyDoc(updated[0]), fields)
+			}
+		}
+		lastError[0].Value = 1
+		lastError[1].Value = true
+	case truthy(first(lookup(cmd, "upsert"))):
+		_, _, upserted, err := s.store.update(db, name, docArg(cmd, "query"), update, arrayFilters, false, true)
+		if err != nil {
+			return nil, err
+		}
+		if returnNew {
+			created, _ := s.store.find(db, name, bson.D{{Name: "_id", Value: upserted}})
+			if len(created) > 0 {
+				value = project(copyDoc(created[0]), fields)
+			}
+		}
+		lastError[0].Value = 1
+		lastError = append(lastError, bson.DocElem{Name: "upserted", Value: upserted})
+	}
+	return bson.D{{Name: "lastErrorObject", Value: lastError}, {Name: "value", Value: value}}, nil
+}
+
+// collectionOptions lists the options of create kept by the server and
+// reported by listCollections, with whether collMod may change them.
+var collectionOptions = map[string]bool{
+	"capped":           false,
+	"size":             false,
+	"max":              false,
+	"collation":        false,
+	"validator":        true,
+	"validationLevel":  true,
+	"validationAction": true,
+}
+
+func (s *Server) create(db string, cmd bson.D) error {
+	name := stringArg(cmd, "create")
+	s.store.mu.Lock()
+	defer s.store.mu.Unlock()
+	if s.store.collection(db, name, false) != nil {
+		return &queryError{Code: 48, Message: "collection already exists"}
+	}
+	c := s.store.collection(db, name, true)
+	for _, e := range cmd {
+		if _, ok := collectionOptions[e.Name]; ok {
+			c.options = append(c.options, bson.DocElem{Name: e.Name, Value: copyValue(e.Value)})
+		}
+	}
+	return nil
+}
+
+func (s *Server) collMod(db string, cmd bson.D) error {
+	s.store.mu.Lock()
+	defer s.store.mu.Unlock()
+	c := s.store.collection(db, stringArg(cmd, "collMod"), false)
+	if c == nil {
+		return &queryError{Code: 26, Message: "ns does not exist"}
+	}
+	for _, e := range cmd[1:] {
+		if !collectionOptions[e.Name] {
+			return &queryError{Code: 72, Message: "unknown option to collMod: " + e.Name}
+		}
+		c.options = setField(c.options, e.Name, copyValue(e.Value))
+	}
+	return nil
+}
+
+func (s *Server) listCollections(db string, cmd bson.D) ([]bson.D, error) {
+	filter := docArg(cmd, "filter")
+	var docs []bson.D
+	for _, name := range s.store.CollectionNames(db) {
+		s.store.mu.Lock()
+		c := s.store.collection(db, name, false)
+		var options bson.D
+		if c != nil {
+			options = copyDoc(c.options)
+		}
+		s.store.mu.Unlock()
+		if c == nil {
+			continue
+		}
+		doc := bson.D{{Name: "name", Value: name}, {Name: "type", Value: "collection"}, {Name: "options", Value: options}}
+		ok, err := match(doc, filter)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			docs = append(docs, doc)
+		}
+	}
+	return docs, nil
+}
+
+func (s *Server) createIndexes(db string, cmd bson.D) (bson.D, error) {
+	list, _ := first(lookup(cmd, "indexes")).([]interface{})
+	s.store.mu.Lock()
+	defer s.store.mu.Unlock()
+	c := s.store.collection(db, stringArg(cmd, "createIndexes"), true)
+	before := len(c.indexes)
+	for _, item := range list {
+		spec, ok := asDoc(item)
+		if !ok {
+			return nil, badValue("index specification must be an object")
+		}
+		name, _ := first(lookup(spec, "name")).(string)
+		existing := -1
+		for i, index := range c.indexes {
+			if first(lookup(index, "name")) == name {
+				existing = i
+			}
+		}
+		if existing >= 0 {
+			if compare(first(lookup(c.indexes[existing], "key")), first(lookup(spec, "key"))) != 0 {
+				return nil, &queryError{Code: 86, Message: "index with name " + name + " already exists with different options"}
+			}
+			continue
+		}
+		if !truthy(first(lookup(spec, "v"))) {
+			spec = append(bson.D{{Name: "v", Value: 2}}, spec...)
+		}
+		c.indexes = append(c.indexes, copyDoc(spec))
+		if err := checkIndex(c); err != nil {
+			c.indexes = c.indexes[:len(c.indexes)-1]
+			return nil, err
+		}
+	}
+	return bson.D{
+		{Name: "numIndexesBefore", Value: before},
+		{Name: "numIndexesAfter", Value: len(c.indexes)},
+	}, nil
+}
+
+// checkIndex verifies that the documents of c satisfy its unique indexes.
+func checkIndex(c *collection) error {
+	docs := c.docs
+	c.docs = nil
+	defer func() { c.docs = docs }()
+	for _, doc := range docs {
+		if err := c.checkUnique(doc, -1); err != nil {
+			return err
+		}
+		c.docs = append(c.docs, doc)
+	}
+	return nil
+}
+
+func (s *Server) listIndexes(db string, cmd bson.D) (bson.D, error) {
+	name := stringArg(cmd, "listIndexes")
+	s.store.mu.Lock()
+	c := s.store.collection(db, name, false)
+	var indexes []bson.D
+	if c != nil {
+		for _, index := range c.indexes {
+			indexes = append(indexes, copyDoc(index))
+		}
+	}
+	s.store.mu.Unlock()
+	if c == nil {
+		return nil, &queryError{Code: 26, Message: "ns does not exist: " + db + "." + name}
+	}
+	return s.cursorReply(db+"."+name, indexes, 0, false, "firstBatch"), nil
+}
+
+func (s *Server) dropIndexes(db string, cmd bson.D) (bson.D, error) {
+	s.store.mu.Lock()
+	defer s.store.mu.Unlock()
+	c := s.store.collection(db, stringArg(cmd, cmd[0].Name), false)
+	if c == nil {
+		return nil, &queryError{Code: 26, Message: "ns not found"}
+	}
+	before := len(c.indexes)
+	target := first(lookup(cmd, "index"))
+	var kept []bson.D
+	for _, index := range c.indexes {
+		name := first(lookup(index, "name"))
+		if name == "_id_" || (target != "*" && name != target && compare(first(lookup(index, "key")), target) != 0) {
+			kept = append(kept, index)
+		}
+	}
+	if target != "*" && len(kept) == before {
+		return nil, &queryError{Code: 27, Message: fmt.Sprintf("index not found with name [%v]", target)}
+	}
+	c.indexes = kept
+	return bson.D{{Name: "nIndexesWas", Value: before}}, nil
+}
+
+func stringArg(cmd bson.D, name string) string {
+	s, _ := first(lookup(cmd, name)).(string)
+	return s
+}
+
+func intArg(cmd bson.D, name string) int {
+	n, _ := toFloat(first(lookup(cmd, name)))
+	return int(n)
+}
+
+func docArg(cmd bson.D, name string) bson.D {
+	d, _ := asDoc(first(lookup(cmd, name)))
+	return d
+}
+
+func orderedArg(cmd bson.D) bool {
+	v := lookup(cmd, "ordered")
+	return len(v) == 0 || truthy(v[0])
+}
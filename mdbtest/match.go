@@ -0,0 +1,577 @@
+package mdbtest
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// queryError is reported to the client as a failed command.
+type queryError struct {
+	Code    int
+	Message string
+}
+
+func (e *queryError) Error() string {
+	return e.Message
+}
+
+func badValue(format string, args ...interface{}) error {
+	return &queryError{Code: 2, Message: fmt.Sprintf(format, args...)}
+}
+
+// match reports whether doc satisfies the query filter.
+func match(doc bson.D, filter bson.D) (bool, error) {
+	for _, e := range filter {
+		ok, err := matchElem(doc, e)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func matchElem(doc bson.D, e bson.DocElem) (bool, error) {
+	switch e.Name {
+	case "$and", "$or", "$nor":
+		list, ok := e.Value.([]interface{})
+		if !ok {
+			return false, badValue("%s must be an array", e.Name)
+		}
+		for _, item := range list {
+			sub, ok := asDoc(item)
+			if !ok {
+				return false, badValue("%s entries must be documents", e.Name)
+			}
+			ok, err := match(doc, sub)
+			if err != nil {
+				return false, err
+			}
+			switch {
+			case e.Name == "$and" && !ok:
+				return false, nil
+			case e.Name == "$or" && ok:
+				return true, nil
+			case e.Name == "$nor" && ok:
+				return false, nil
+			}
+		}
+		return e.Name != "$or", nil
+	case "$comment":
+		return true, nil
+	}
+	if strings.HasPrefix(e.Name, "$") {
+		return false, badValue("unknown top level operator: %s", e.Name)
+	}
+	return matchValue(lookup(doc, e.Name), e.Value)
+}
+
+// matchValue reports whether the values found at a path satisfy cond, which
+// is either a literal value or a document of operators.
+func matchValue(values []interface{}, cond interface{}) (bool, error) {
+	ops, ok := asDoc(cond)
+	if !ok || len(ops) == 0 || !strings.HasPrefix(ops[0].Name, "$") {
+		if re, ok := cond.(bson.RegEx); ok {
+			return matchRegex(values, re)
+		}
+		return anyEqual(values, cond), nil
+	}
+	var regex *bson.RegEx
+	for _, op := range ops {
+		var ok bool
+		var err error
+		switch op.Name {
+		case "$eq":
+			ok = anyEqual(values, op.Value)
+		case "$ne":
+			ok = !anyEqual(values, op.Value)
+		case "$gt", "$gte", "$lt", "$lte":
+			ok = anyCompare(values, op.Name, op.Value)
+		case "$in", "$nin":
+			list, isList := op.Value.([]interface{})
+			if !isList {
+				return false, badValue("%s needs an array", op.Name)
+			}
+			for _, v := range list {
+				if re, isRe := v.(bson.RegEx); isRe {
+					ok, err = matchRegex(values, re)
+				} else {
+					ok = anyEqual(values, v)
+				}
+				if err != nil {
+					return false, err
+				}
+				if ok {
+					break
+				}
+			}
+			if op.Name == "$nin" {
+				ok = !ok
+			}
+		case "$exists":
+			ok = (len(values) > 0) == truthy(op.Value)
+		case "$type":
+			types, isList := op.Value.([]interface{})
+			if !isList {
+				types = []interface{}{op.Value}
+			}
+			for _, t := range types {
+				if ok, err = anyType(values, t); ok || err != nil {
+					break
+				}
+			}
+		case "$not":
+			ok, err = matchValue(values, op.Value)
+			ok = !ok
+		case "$regex":
+			if regex == nil {
+				regex = &bson.RegEx{}
+			}
+			switch v := op.Value.(type) {
+			case string:
+				regex.Pattern = v
+			case bson.RegEx:
+				regex.Pattern, regex.Options = v.Pattern, v.Options
+			default:
+				return false, badValue("$regex has to be a string")
+			}
+			continue
+		case "$options":
+			if regex == nil {
+				regex = &bson.RegEx{}
+			}
+			regex.Options, _ = op.Value.(string)
+			continue
+		case "$size":
+			n, isNum := toFloat(op.Value)
+			if !isNum {
+				return false, badValue("$size needs a number")
+			}
+			for _, v := range values {
+				if list, isList := v.([]interface{}); isList && float64(len(list)) == n {
+					ok = true
+				}
+			}
+		case "$all":
+			list, isList := op.Value.([]interface{})
+			if !isList {
+				return false, badValue("$all needs an array")
+			}
+			ok = len(list) > 0
+			for _, v := range list {
+				if !anyEqual(values, v) {
+					ok = false
+				}
+			}
+		case "$elemMatch":
+			sub, isDoc := asDoc(op.Value)
+			if !isDoc {
+				return false, badValue("$elemMatch needs an Object")
+			}
+			ok, err = elemMatch(values, sub)
+		case "$mod":
+			list, isList := op.Value.([]interface{})
+			if !isList || len(list) != 2 {
+				return false, badValue("malformed mod, needs to be an array of 2 numbers")
+			}
+			div, _ := toFloat(list[0])
+			rem, _ := toFloat(list[1])
+			for _, v := range values {
+				if n, isNum := toFloat(v); isNum && div != 0 && math.Mod(math.Trunc(n), div) == rem {
+					ok = true
+				}
+			}
+		default:
+			return false, badValue("unknown operator: %s", op.Name)
+		}
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	if regex != nil {
+		return matchRegex(values, *regex)
+	}
+	return true, nil
+}
+
+func elemMatch(values []interface{}, cond bson.D) (bool, error) {
+	operators := len(cond) > 0 && strings.HasPrefix(cond[0].Name, "$") &&
+		cond[0].Name != "$and" && cond[0].Name != "$or" && cond[0].Name != "$nor"
+	for _, v := range values {
+		list, ok := v.([]interface{})
+		if !ok {
+			continue
+		}
+		for _, item := range list {
+			var ok bool
+			var err error
+			if operators {
+				ok, err = matchValue([]interface{}{item}, cond)
+			} else if doc, isDoc := asDoc(item); isDoc {
+				ok, err = match(doc, cond)
+			}
+			if err != nil {
+				return false, err
+			}
+			if ok {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+func matchRegex(values []interface{}, re bson.RegEx) (bool, error) {
+	pattern := re.Pattern
+	if flags := strings.Map(func(r rune) rune {
+		if strings.ContainsRune("ims", r) {
+			return r
+		}
+		return -1
+	}, re.Options); flags != "" {
+		pattern = "(?" + flags + ")" + pattern
+	}
+	r, err := regexp.Compile(pattern)
+	if err != nil {
+		return false, badValue("invalid regular expression: %v", err)
+	}
+	for _, v := range expand(values) {
+		if s, ok := v.(string); ok && r.MatchString(s) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// expand adds the elements of any array in values to the candidates, as
+// MongoDB does when matching array fields against scalar conditions.
+func expand(values []interface{}) []interface{} {
+	out := values
+	for _, v := range values {
+		if list, ok := v.([]interface{}); ok {
+			out = append(out[:len(out):len(out)], list...)
+		}
+	}
+	return out
+}
+
+func anyEqual(values []interface{}, want interface{}) bool {
+	if want == nil && len(values) == 0 {
+		return true
+	}
+	for _, v := range expand(values) {
+		if compare(v, want) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func anyCompare(values []interface{}, op string, want interface{}) bool {
+	for _, v := range expand(values) {
+		if typeOrder(v) != typeOrder(want) {
+			continue
+		}
+		c := compare(v, want)
+		switch op {
+		case "$gt":
+			if c > 0 {
+				return true
+			}
+		case "$gte":
+			if c >= 0 {
+				return true
+			}
+		case "$lt":
+			if c < 0 {
+				return true
+			}
+		case "$lte":
+			if c <= 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// bsonTypes maps the aliases accepted by $type to BSON type numbers.
+var bsonTypes = map[string]int{
+	"double": 1, "string": 2, "object": 3, "array": 4, "binData": 5,
+	"objectId": 7, "bool": 8, "date": 9, "null": 10, "regex": 11,
+	"int": 16, "timestamp": 17, "long": 18,
+}
+
+// bsonType returns the BSON type number of v.
+func bsonType(v interface{}) int {
+	switch v.(type) {
+	case float64, float32:
+		return 1
+	case string, bson.Symbol:
+		return 2
+	case bson.D, bson.M:
+		return 3
+	case []interface{}:
+		return 4
+	case []byte, bson.Binary:
+		return 5
+	case bson.ObjectId:
+		return 7
+	case bool:
+		return 8
+	case time.Time:
+		return 9
+	case nil:
+		return 10
+	case bson.RegEx:
+		return 11
+	case int, int32:
+		return 16
+	case bson.MongoTimestamp:
+		return 17
+	case int64:
+		return 18
+	}
+	return 0
+}
+
+// anyType reports whether any of values, or of their elements, is of the
+// given BSON type, given by number or alias, "number" matching any number.
+func anyType(values []interface{}, t interface{}) (bool, error) {
+	want, isNum := toFloat(t)
+	alias, isAlias := t.(string)
+	if isAlias && alias != "number" {
+		n, known := bsonTypes[alias]
+		if !known {
+			return false, badValue("unknown type name alias: %s", alias)
+		}
+		want, isNum = float64(n), true
+	}
+	if !isNum && !isAlias {
+		return false, badValue("type must be represented as a number or a string")
+	}
+	for _, v := range expand(values) {
+		if alias == "number" && typeOrder(v) == 2 || isNum && float64(bsonType(v)) == want {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// lookup returns every value reachable through the dotted path, descending
+// into arrays of documents along the way.
+func lookup(doc interface{}, path string) []interface{} {
+	return lookupParts(doc, strings.Split(path, "."))
+}
+
+func lookupParts(v interface{}, parts []string) []interface{} {
+	if len(parts) == 0 {
+		return []interface{}{v}
+	}
+	if doc, ok := asDoc(v); ok {
+		for _, e := range doc {
+			if e.Name == parts[0] {
+				return lookupParts(e.Value, parts[1:])
+			}
+		}
+		return nil
+	}
+	if list, ok := v.([]interface{}); ok {
+		if i, err := strconv.Atoi(parts[0]); err == nil {
+			if i >= 0 && i < len(list) {
+				return lookupParts(list[i], parts[1:])
+			}
+			return nil
+		}
+		var out []interface{}
+		for _, item := range list {
+			if _, ok := asDoc(item); ok {
+				out = append(out, lookupParts(item, parts)...)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// asDoc returns v as a bson.D if it holds a document.
+func asDoc(v interface{}) (bson.D, bool) {
+	switch d := v.(type) {
+	case bson.D:
+		return d, true
+	case bson.M:
+		out := make(bson.D, 0, len(d))
+		for k, v := range d {
+			out = append(out, bson.DocElem{Name: k, Value: v})
+		}
+		return out, true
+	case bson.RawD:
+		out := make(bson.D, 0, len(d))
+		for _, e := range d {
+			var v interface{}
+			if err := e.Value.Unmarshal(&v); err != nil {
+				return nil, false
+			}
+			out = append(out, bson.DocElem{Name: e.Name, Value: v})
+		}
+		return out, true
+	}
+	return nil, false
+}
+
+func truthy(v interface{}) bool {
+	switch b := v.(type) {
+	case bool:
+		return b
+	case nil:
+		return false
+	}
+	n, ok := toFloat(v)
+	return !ok || n != 0
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+// typeOrder returns the position of v's type in MongoDB's comparison order.
+func typeOrder(v interface{}) int {
+	switch v.(type) {
+	case nil:
+		return 1
+	case int, int32, int64, float64, float32:
+		return 2
+	case string, bson.Symbol:
+		return 3
+	case bson.D, bson.M:
+		return 4
+	case []interface{}:
+		return 5
+	case []byte, bson.Binary:
+		return 6
+	case bson.ObjectId:
+		return 7
+	case bool:
+		return 8
+	case time.Time:
+		return 9
+	case bson.MongoTimestamp:
+		return 10
+	case bson.RegEx:
+		return 11
+	}
+	return 12
+}
+
+// compare orders two BSON values the way MongoDB does.
+func compare(a, b interface{}) int {
+	ta, tb := typeOrder(a), typeOrder(b)
+	if ta != tb {
+		return sign(ta - tb)
+	}
+	switch x := a.(type) {
+	case int, int32, int64, float64, float32:
+		if ia, ok := a.(int64); ok {
+			if ib, ok := b.(int64); ok {
+				return compareInt(ia, ib)
+			}
+		}
+		fa, _ := toFloat(x)
+		fb, _ := toFloat(b)
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		}
+		return 0
+	case string:
+		return strings.Compare(x, fmt.Sprint(b))
+	case bson.Symbol:
+		return strings.Compare(string(x), fmt.Sprint(b))
+	case bson.ObjectId:
+		return strings.Compare(string(x), string(b.(bson.ObjectId)))
+	case bool:
+		y := b.(bool)
+		switch {
+		case x == y:
+			return 0
+		case y:
+			return -1
+		}
+		return 1
+	case time.Time:
+		y := b.(time.Time)
+		switch {
+		case x.Before(y):
+			return -1
+		case x.After(y):
+			return 1
+		}
+		return 0
+	case bson.MongoTimestamp:
+		return compareInt(int64(x), int64(b.(bson.MongoTimestamp)))
+	case []byte:
+		y, _ := b.([]byte)
+		return bytes.Compare(x, y)
+	case []interface{}:
+		y := b.([]interface{})
+		for i := 0; i < len(x) && i < len(y); i++ {
+			if c := compare(x[i], y[i]); c != 0 {
+				return c
+			}
+		}
+		return sign(len(x) - len(y))
+	case bson.D, bson.M:
+		dx, _ := asDoc(a)
+		dy, _ := asDoc(b)
+		for i := 0; i < len(dx) && i < len(dy); i++ {
+			if c := strings.Compare(dx[i].Name, dy[i].Name); c != 0 {
+				return c
+			}
+			if c := compare(dx[i].Value, dy[i].Value); c != 0 {
+				return c
+			}
+		}
+		return sign(len(dx) - len(dy))
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func compareInt(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,344 @@
+// Package mdbtest provides an in-process MongoDB stand-in for tests.
+//
+// The Server speaks enough of the MongoDB wire protocol for mgo, and so for
+// mdb, to connect, query, write and iterate cursors against an in-memory
+// Store, with no mongod required:
+//
+//     srv := mdbtest.NewServer()
+//     defer srv.Close()
+//
+//     db, err := mdb.Dial(srv.URL("test"))
+//     if err != nil {
+//         t.Fatal(err)
+//     }
+//     defer db.Close()
+//
+// Broken connections can be scripted with DropNext, which closes the client
+// connection instead of answering a command, so the retry and refresh paths
+// of mdb run against the real mgo code. DropAfterNext runs the command
+// first, for writes which were applied but never acknowledged.
+//
+// Collection options such as validators are stored and reported by
+// listCollections, but documents are not validated against them. The
+// aggregate command runs the common stages; $merge and most expression
+// operators are not supported.
+package mdbtest
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// Server is a MongoDB wire protocol server backed by a Store.
+type Server struct {
+	listener net.Listener
+	store    *Store
+	wg       sync.WaitGroup
+
+	mu       sync.Mutex
+	conns    map[net.Conn]bool
+	cursors  map[int64]*cursor
+	drops    []*drop
+	received map[string]int
+	closed   bool
+
+	lastCursor int64
+	lastID     int32
+}
+
+// cursor holds the documents of a query which are yet to be returned.
+type cursor struct {
+	ns   string
+	docs []bson.D
+}
+
+// drop makes the server close the connection instead of answering the next
+// n commands with the given name, after running them if after is set.
+type drop struct {
+	command string
+	n       int
+	after   bool
+}
+
+// NewServer starts a Server on a random local port with an empty Store.
+// It panics if the listener cannot be created, like httptest.NewServer.
+func NewServer() *Server {
+	return NewServerWithStore(NewStore())
+}
+
+// NewServerWithStore starts a Server on a random local port answering from
+// the provided store.
+func NewServerWithStore(store *Store) *Server {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic("mdbtest: failed to listen on a port: " + err.Error())
+	}
+	s := &Server{
+		listener: l,
+		store:    store,
+		conns:    map[net.Conn]bool{},
+		cursors:  map[int64]*cursor{},
+		received: map[string]int{},
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s
+}
+
+// Addr returns the host:port the server is listening on.
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// URL returns a connection string for the server using db as the default
+// database, suitable for mdb.Dial.
+func (s *Server) URL(db string) string {
+	return "mongodb://" + s.Addr() + "/" + db
+}
+
+// Store returns the store the server answers from.
+func (s *Server) Store() *Store {
+	return s.store
+}
+
+// DropNext makes the server close the client connection, without answering,
+// when it receives each of the next n commands with the given name, such as
+// "insert" or "getMore". The empty name matches any command. It does
+// nothing when n is below 1.
+func (s *Server) DropNext(command string, n int) {
+	s.addDrop(&drop{command: strings.ToLower(command), n: n})
+}
+
+// DropAfterNext works like DropNext, but the commands are run before the
+// connection is closed, as when a connection breaks while the reply is on
+// its way.
+func (s *Server) DropAfterNext(command string, n int) {
+	s.addDrop(&drop{command: strings.ToLower(command), n: n, after: true})
+}
+
+func (s *Server) addDrop(d *drop) {
+	if d.n <= 0 {
+		return
+	}
+	s.mu.Lock()
+	s.drops = append(s.drops, d)
+	s.mu.Unlock()
+}
+
+// Received returns how many commands with the given name the server has
+// received, including those for which the connection was dropped. The empty
+// name returns the total.
+func (s *Server) Received(command string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if command != "" {
+		return s.received[strings.ToLower(command)]
+	}
+	var n int
+	for _, c := range s.received {
+		n += c
+	}
+	return n
+}
+
+// CloseConnections closes every open client connection, while the server
+// keeps accepting new ones.
+func (s *Server) CloseConnections() {
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+}
+
+// Close stops the server and closes every client connection.
+func (s *Server) Close() {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	s.listener.Close()
+	s.CloseConnections()
+	s.wg.Wait()
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = true
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+	for {
+		h, body, err := readMessage(conn)
+		if err != nil {
+			return
+		}
+		var reply []byte
+		var opCode int32
+		switch h.OpCode {
+		case opQuery:
+			q, err := parseQuery(body)
+			if err != nil {
+				return
+			}
+			var doc interface{}
+			var docs []interface{}
+			if strings.HasSuffix(q.Collection, ".$cmd") {
+				cmd := q.Query
+				if len(cmd) > 0 && (cmd[0].Name == "$query" || cmd[0].Name == "query") {
+					cmd, _ = asDoc(cmd[0].Value)
+				}
+				doc = s.command(strings.TrimSuffix(q.Collection, ".$cmd"), cmd)
+				if doc == nil {
+					return
+				}
+				docs = []interface{}{doc}
+			} else {
+				docs = s.legacyQuery(q)
+			}
+			opCode = opReply
+			reply, err = replyBody(0, docs...)
+			if err != nil {
+				return
+			}
+		case opMsg:
+			flags, cmd, err := parseMsg(body)
+			if err != nil {
+				return
+			}
+			db, _ := first(lookup(cmd, "$db")).(string)
+			doc := s.command(db, unsetPath(cmd, "$db"))
+			if doc == nil {
+				return
+			}
+			if flags&msgMoreToCome != 0 {
+				continue
+			}
+			opCode = opMsg
+			if reply, err = msgBody(doc); err != nil {
+				return
+			}
+		case opKillCursors:
+			r := &reader{data: body}
+			r.int32()
+			for n := r.int32(); n > 0 && r.err == nil; n-- {
+				s.killCursor(r.int64())
+			}
+			continue
+		default:
+			// Legacy opcodes are not needed by servers reporting a wire
+			// version of 4 or later.
+			return
+		}
+		id := atomic.AddInt32(&s.lastID, 1)
+		if err := writeMessage(conn, id, h.RequestID, opCode, reply); err != nil {
+			return
+		}
+	}
+}
+
+// legacyQuery answers an OP_QUERY on a regular collection.
+func (s *Server) legacyQuery(q *queryMsg) []interface{} {
+	filter := q.Query
+	var spec bson.D
+	if v := lookup(filter, "$query"); len(v) > 0 {
+		spec, _ = asDoc(first(lookup(filter, "$orderby")))
+		filter, _ = asDoc(v[0])
+	}
+	db, name := splitNS(q.Collection)
+	s.store.mu.Lock()
+	docs, err := s.store.find(db, name, filter)
+	s.store.mu.Unlock()
+	if err != nil {
+		return []interface{}{errorDoc(err)}
+	}
+	sortDocs(docs, spec)
+	docs = window(docs, int(q.Skip), int(q.Return))
+	out := make([]interface{}, len(docs))
+	for i, doc := range docs {
+		out[i] = project(doc, q.Selector)
+	}
+	return out
+}
+
+// shouldDrop records the command and returns the drop of its connection,
+// if it must be dropped instead of answered.
+func (s *Server) shouldDrop(command string) *drop {
+	command = strings.ToLower(command)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.received[command]++
+	for i, d := range s.drops {
+		if d.command != "" && d.command != command {
+			continue
+		}
+		d.n--
+		if d.n <= 0 {
+			s.drops = append(s.drops[:i], s.drops[i+1:]...)
+		}
+		return d
+	}
+	return nil
+}
+
+func (s *Server) newCursor(ns string, docs []bson.D) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastCursor++
+	s.cursors[s.lastCursor] = &cursor{ns: ns, docs: docs}
+	return s.lastCursor
+}
+
+func (s *Server) killCursor(id int64) {
+	s.mu.Lock()
+	delete(s.cursors, id)
+	s.mu.Unlock()
+}
+
+func splitNS(ns string) (db, name string) {
+	if i := strings.Index(ns, "."); i >= 0 {
+		return ns[:i], ns[i+1:]
+	}
+	return ns, ""
+}
+
+// window applies skip and limit to docs. A negative limit is treated as
+// its absolute value, as in the wire protocol.
+func window(docs []bson.D, skip, limit int) []bson.D {
+	if skip > len(docs) {
+		skip = len(docs)
+	}
+	docs = docs[skip:]
+	if limit < 0 {
+		limit = -limit
+	}
+	if limit > 0 && limit < len(docs) {
+		docs = docs[:limit]
+	}
+	return docs
+}
@@ -0,0 +1,117 @@
+package mdbtest
+
+import (
+	"testing"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/ti/mdb"
+)
+
+type person struct {
+	Name  string
+	Phone string
+	Age   int
+}
+
+func dial(t *testing.T) (*Server, *mdb.Database) {
+	srv := NewServer()
+	db, err := mdb.Dial(srv.URL("test"))
+	if err != nil {
+		srv.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		srv.Close()
+	})
+	return srv, db
+}
+
+func TestServerCRUD(t *testing.T) {
+	_, db := dial(t)
+	c := db.C("people")
+	err := c.Insert(&person{"Ale", "+55 53 8116 9639", 30}, &person{"Cla", "+55 53 8402 8510", 25})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var result person
+	if err := c.Find(bson.M{"name": "Ale"}).One(&result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Phone != "+55 53 8116 9639" {
+		t.Fatalf("got phone %q", result.Phone)
+	}
+
+	if err := c.Update(bson.M{"name": "Cla"}, bson.M{"$inc": bson.M{"age": 1}}); err != nil {
+		t.Fatal(err)
+	}
+	var all []person
+	if err := c.Find(bson.M{"age": bson.M{"$gte": 26}}).Sort("-age").All(&all); err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 || all[0].Name != "Ale" || all[1].Age != 26 {
+		t.Fatalf("unexpected result: %+v", all)
+	}
+
+	info, err := c.Upsert(bson.M{"name": "Bob"}, bson.M{"$set": bson.M{"age": 40}})
+	if err != nil || info.UpsertedId == nil {
+		t.Fatalf("upsert: %v %+v", err, info)
+	}
+	if err := c.Remove(bson.M{"name": "Ale"}); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := c.Count(); err != nil || n != 2 {
+		t.Fatalf("count = %d, %v; want 2", n, err)
+	}
+	if err := c.Find(bson.M{"name": "Ale"}).One(&result); err != mgo.ErrNotFound {
+		t.Fatalf("got %v, want not found", err)
+	}
+}
+
+func TestServerRetriesDroppedConnection(t *testing.T) {
+	srv, db := dial(t)
+	srv.DropNext("insert", 0)
+	srv.DropAfterNext("insert", -1)
+	srv.DropNext("insert", 1)
+	if err := db.C("people").Insert(&person{Name: "Ale"}); err != nil {
+		t.Fatal(err)
+	}
+	if n := srv.Received("insert"); n != 2 {
+		t.Fatalf("insert received %d times, want 2", n)
+	}
+	docs, err := srv.Store().Find("test", "people", nil)
+	if err != nil || len(docs) != 1 {
+		t.Fatalf("stored %d documents, %v; want 1", len(docs), err)
+	}
+}
+
+func TestServerDropMidCursor(t *testing.T) {
+	srv, db := dial(t)
+	for i := 0; i < 5; i++ {
+		if err := srv.Store().Insert("test", "people", person{Name: "p", Age: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	iter := db.C("people").Find(nil).Batch(2).Iter()
+	var p person
+	var n int
+	for iter.Next(&p) {
+		n++
+	}
+	if n != 5 || iter.Err() != nil {
+		t.Fatalf("iterated %d documents, %v; want 5", n, iter.Err())
+	}
+
+	srv.DropNext("getMore", 1)
+	iter = db.C("people").Find(nil).Batch(2).Iter()
+	n = 0
+	for iter.Next(&p) {
+		n++
+	}
+	if n != 2 || iter.Err() == nil {
+		t.Fatalf("iterated %d documents, %v; want 2 and an error", n, iter.Err())
+	}
+}
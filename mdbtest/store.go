@@ -0,0 +1,279 @@
+package mdbtest
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// Store is an in-memory set of databases and collections. It implements
+// enough of MongoDB's query and update language for tests, and is what a
+// Server answers from.
+//
+// Documents are kept as bson.D values in insertion order.
+type Store struct {
+	mu  sync.Mutex
+	dbs map[string]map[string]*collection
+}
+
+type collection struct {
+	docs    []bson.D
+	indexes []bson.D
+	options bson.D
+}
+
+// NewStore returns an empty Store.
+func NewStore() *Store {
+	return &Store{dbs: map[string]map[string]*collection{}}
+}
+
+// Insert adds documents to the named collection, generating an _id for
+// documents that do not have one. The documents may be any value that can be
+// marshalled with bson.
+func (s *Store) Insert(db, name string, docs ...interface{}) error {
+	list := make([]bson.D, len(docs))
+	for i, doc := range docs {
+		d, err := toDoc(doc)
+		if err != nil {
+			return err
+		}
+		list[i] = d
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, errs := s.insert(db, name, list, true)
+	return errs[n]
+}
+
+// Find returns copies of the documents of the named collection matching
+// filter, in insertion order. A nil filter matches every document.
+func (s *Store) Find(db, name string, filter interface{}) ([]bson.D, error) {
+	f, err := toDoc(filter)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	docs, err := s.find(db, name, f)
+	if err != nil {
+		return nil, err
+	}
+	for i, doc := range docs {
+		docs[i] = copyDoc(doc)
+	}
+	return docs, nil
+}
+
+// Drop removes the named collection and its documents.
+func (s *Store) Drop(db, name string) {
+	s.mu.Lock()
+	delete(s.dbs[db], name)
+	s.mu.Unlock()
+}
+
+// Reset removes every database.
+func (s *Store) Reset() {
+	s.mu.Lock()
+	s.dbs = map[string]map[string]*collection{}
+	s.mu.Unlock()
+}
+
+// CollectionNames returns the sorted names of the collections in db.
+func (s *Store) CollectionNames(db string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var names []string
+	for name := range s.dbs[db] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// collection returns the named collection, creating it if create is set.
+func (s *Store) collection(db, name string, create bool) *collection {
+	cols := s.dbs[db]
+	if cols == nil {
+		if !create {
+			return nil
+		}
+		cols = map[string]*collection{}
+		s.dbs[db] = cols
+	}
+	c := cols[name]
+	if c == nil && create {
+		c = &collection{indexes: []bson.D{{
+			{Name: "v", Value: 2},
+			{Name: "key", Value: bson.D{{Name: "_id", Value: 1}}},
+			{Name: "name", Value: "_id_"},
+			{Name: "ns", Value: db + "." + name},
+		}}}
+		cols[name] = c
+	}
+	return c
+}
+
+// insert adds docs to a collection and returns how many were inserted.
+// With ordered set it stops at the first error; the index of every failed
+// document is returned with its error.
+func (s *Store) insert(db, name string, docs []bson.D, ordered bool) (n int, errs map[int]error) {
+	c := s.collection(db, name, true)
+	errs = map[int]error{}
+	for i, doc := range docs {
+		if len(lookup(doc, "_id")) == 0 {
+			doc = append(bson.D{{Name: "_id", Value: bson.NewObjectId()}}, doc...)
+		}
+		if err := c.checkUnique(doc, -1); err != nil {
+			errs[i] = err
+			if ordered {
+				break
+			}
+			continue
+		}
+		c.docs = append(c.docs, copyDoc(doc))
+		n++
+	}
+	return n, errs
+}
+
+// find returns the documents matching filter, without copying them.
+func (s *Store) find(db, name string, filter bson.D) ([]bson.D, error) {
+	c := s.collection(db, name, false)
+	if c == nil {
+		return nil, nil
+	}
+	var out []bson.D
+	for _, doc := range c.docs {
+		ok, err := match(doc, filter)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			out = append(out, doc)
+		}
+	}
+	return out, nil
+}
+
+// update modifies the documents matching filter, upserting one if none
+// matched and upsert is set. It returns the number of matched and modified
+// documents and the _id of the upserted document, if any.
+func (s *Store) update(db, name string, filter, update bson.D, arrayFilters []interface{}, multi, upsert bool) (matched, modified int, upserted interface{}, err error) {
+	c := s.collection(db, name, true)
+	for i, doc := range c.docs {
+		ok, err := match(doc, filter)
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		if !ok {
+			continue
+		}
+		matched++
+		changed, err := applyUpdate(doc, update, false, filter, arrayFilters)
+		if err != nil {
+			return matched, modified, nil, err
+		}
+		if err := c.checkUnique(changed, i); err != nil {
+			return matched, modified, nil, err
+		}
+		if compare(doc, changed) != 0 {
+			c.docs[i] = changed
+			modified++
+		}
+		if !multi {
+			break
+		}
+	}
+	if matched > 0 || !upsert {
+		return matched, modified, nil, nil
+	}
+	doc, err := applyUpdate(upsertBase(filter), update, true, filter, arrayFilters)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	if !isOperatorDoc(update) {
+		if id := lookup(filter, "_id"); len(id) > 0 && len(lookup(doc, "_id")) == 0 {
+			doc = append(bson.D{{Name: "_id", Value: id[0]}}, doc...)
+		}
+	}
+	if len(lookup(doc, "_id")) == 0 {
+		doc = append(bson.D{{Name: "_id", Value: bson.NewObjectId()}}, doc...)
+	}
+	if err := c.checkUnique(doc, -1); err != nil {
+		return 0, 0, nil, err
+	}
+	c.docs = append(c.docs, doc)
+	return 0, 0, lookup(doc, "_id")[0], nil
+}
+
+// remove deletes the documents matching filter, at most limit of them when
+// limit is positive, and returns how many were removed.
+func (s *Store) remove(db, name string, filter bson.D, limit int) (int, error) {
+	c := s.collection(db, name, false)
+	if c == nil {
+		return 0, nil
+	}
+	var kept []bson.D
+	var n int
+	for _, doc := range c.docs {
+		if limit <= 0 || n < limit {
+			ok, err := match(doc, filter)
+			if err != nil {
+				return 0, err
+			}
+			if ok {
+				n++
+				continue
+			}
+		}
+		kept = append(kept, doc)
+	}
+	c.docs = kept
+	return n, nil
+}
+
+// checkUnique returns a duplicate key error if doc would violate a unique
+// index of c. The document at position self is ignored.
+func (c *collection) checkUnique(doc bson.D, self int) error {
+	for _, index := range c.indexes {
+		key, _ := asDoc(first(lookup(index, "key")))
+		name, _ := first(lookup(index, "name")).(string)
+		if name != "_id_" && !truthy(first(lookup(index, "unique"))) {
+			continue
+		}
+		for i, other := range c.docs {
+			if i == self {
+				continue
+			}
+			same := true
+			for _, k := range key {
+				if compare(first(lookup(doc, k.Name)), first(lookup(other, k.Name))) != 0 {
+					same = false
+					break
+				}
+			}
+			if same {
+				return &queryError{Code: 11000, Message: "E11000 duplicate key error index: " + name}
+			}
+		}
+	}
+	return nil
+}
+
+// toDoc converts any value that can be marshalled with bson into a bson.D.
+func toDoc(v interface{}) (bson.D, error) {
+	if v == nil {
+		return bson.D{}, nil
+	}
+	if d, ok := v.(bson.D); ok {
+		return d, nil
+	}
+	data, err := bson.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var d bson.D
+	err = bson.Unmarshal(data, &d)
+	return d, err
+}
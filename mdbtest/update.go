@@ -0,0 +1,556 @@
+package mdbtest
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// isOperatorDoc reports whether an update document uses $ operators rather
+// than replacing the whole document.
+func isOperatorDoc(update bson.D) bool {
+	return len(update) > 0 && strings.HasPrefix(update[0].Name, "$")
+}
+
+// applyUpdate returns a copy of doc modified by update. The insert flag
+// enables $setOnInsert, used when the document is being upserted. The
+// filter which matched doc resolves the $ positional operator, and
+// arrayFilters the $[<identifier>] ones.
+func applyUpdate(doc bson.D, update bson.D, insert bool, filter bson.D, arrayFilters []interface{}) (bson.D, error) {
+	if !isOperatorDoc(update) {
+		out := copyDoc(update)
+		if id := lookup(doc, "_id"); len(id) > 0 {
+			out = setField(out, "_id", id[0])
+		}
+		return out, nil
+	}
+	out := copyDoc(doc)
+	for _, op := range update {
+		fields, ok := asDoc(op.Value)
+		if !ok {
+			return nil, badValue("modifier %s needs a document", op.Name)
+		}
+		for _, f := range fields {
+			paths := []string{f.Name}
+			if strings.Contains(f.Name, "$") {
+				var err error
+				if paths, err = positionalPaths(out, f.Name, filter, arrayFilters); err != nil {
+					return nil, err
+				}
+			}
+			for _, path := range paths {
+				var err error
+				out, err = applyOperator(out, op.Name, path, f.Value, insert)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return out, nil
+}
+
+// positionalPaths expands the positional operators $, $[] and
+// $[<identifier>] of path into the paths of the array elements they select
+// in doc.
+func positionalPaths(doc bson.D, path string, filter bson.D, arrayFilters []interface{}) ([]string, error) {
+	paths := []string{""}
+	for _, part := range strings.Split(path, ".") {
+		if !strings.HasPrefix(part, "$") {
+			for i := range paths {
+				paths[i] = joinPath(paths[i], part)
+			}
+			continue
+		}
+		var next []string
+		for _, prefix := range paths {
+			list, _ := first(lookup(doc, prefix)).([]interface{})
+			switch {
+			case part == "$":
+				i := matchedElement(doc, prefix, list, filter)
+				if i < 0 {
+					return nil, badValue("The positional operator did not find the match needed from the query.")
+				}
+				next = append(next, joinPath(prefix, strconv.Itoa(i)))
+			case part == "$[]":
+				for i := range list {
+					next = append(next, joinPath(prefix, strconv.Itoa(i)))
+				}
+			case strings.HasPrefix(part, "$[") && strings.HasSuffix(part, "]"):
+				id := part[2 : len(part)-1]
+				cond := arrayFilterFor(id, arrayFilters)
+				if cond == nil {
+					return nil, badValue("No array filter found for identifier '%s' in path '%s'", id, path)
+				}
+				for i, item := range list {
+					ok, err := match(bson.D{{Name: id, Value: item}}, cond)
+					if err != nil {
+						return nil, err
+					}
+					if ok {
+						next = append(next, joinPath(prefix, strconv.Itoa(i)))
+					}
+				}
+			default:
+				return nil, badValue("unknown positional operator %s in path '%s'", part, path)
+			}
+		}
+		paths = next
+	}
+	return paths, nil
+}
+
+// matchedElement returns the position of the first element of the array at
+// prefix for which doc matches filter, or -1.
+func matchedElement(doc bson.D, prefix string, list []interface{}, filter bson.D) int {
+	for i, item := range list {
+		single, err := setPath(copyDoc(doc), prefix, []interface{}{item})
+		if err != nil {
+			return -1
+		}
+		if ok, _ := match(single, filter); ok {
+			return i
+		}
+	}
+	return -1
+}
+
+// arrayFilterFor merges the array filters on identifier id, or returns nil
+// if there is none.
+func arrayFilterFor(id string, arrayFilters []interface{}) bson.D {
+	var cond bson.D
+	for _, item := range arrayFilters {
+		f, _ := asDoc(item)
+		for _, e := range f {
+			if e.Name == id || strings.HasPrefix(e.Name, id+".") {
+				cond = append(cond, e)
+			}
+		}
+	}
+	return cond
+}
+
+func joinPath(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
+
+func applyOperator(doc bson.D, op, path string, arg interface{}, insert bool) (bson.D, error) {
+	current := lookup(doc, path)
+	var old interface{}
+	if len(current) > 0 {
+		old = current[0]
+	}
+	switch op {
+	case "$set":
+		return setPath(doc, path, copyValue(arg))
+	case "$setOnInsert":
+		if !insert {
+			return doc, nil
+		}
+		return setPath(doc, path, copyValue(arg))
+	case "$unset":
+		return unsetPath(doc, path), nil
+	case "$inc", "$mul":
+		if old == nil {
+			old = 0
+		}
+		v, err := arithmetic(op, old, arg)
+		if err != nil {
+			return nil, err
+		}
+		return setPath(doc, path, v)
+	case "$min", "$max":
+		c := compare(arg, old)
+		if len(current) == 0 || op == "$min" && c < 0 || op == "$max" && c > 0 {
+			return setPath(doc, path, copyValue(arg))
+		}
+		return doc, nil
+	case "$currentDate":
+		return setPath(doc, path, time.Now())
+	case "$rename":
+		to, ok := arg.(string)
+		if !ok {
+			return nil, badValue("$rename target must be a string")
+		}
+		if len(current) == 0 {
+			return doc, nil
+		}
+		return setPath(unsetPath(doc, path), to, old)
+	case "$push", "$addToSet":
+		list, err := arrayAt(old, len(current) > 0, path)
+		if err != nil {
+			return nil, err
+		}
+		items := []interface{}{arg}
+		if each, ok := asDoc(arg); ok && len(each) > 0 && each[0].Name == "$each" {
+			items, ok = each[0].Value.([]interface{})
+			if !ok {
+				return nil, badValue("$each needs an array")
+			}
+		}
+		for _, item := range items {
+			if op == "$addToSet" && anyEqual([]interface{}{list}, item) {
+				continue
+			}
+			list = append(list, copyValue(item))
+		}
+		return setPath(doc, path, list)
+	case "$pull", "$pullAll":
+		list, err := arrayAt(old, len(current) > 0, path)
+		if err != nil || len(list) == 0 {
+			return doc, err
+		}
+		var kept []interface{}
+		for _, item := range list {
+			var remove bool
+			if op == "$pullAll" {
+				values, ok := arg.([]interface{})
+				if !ok {
+					return nil, badValue("$pullAll requires an array argument")
+				}
+				remove = anyEqual(values, item)
+			} else if cond, isDoc := asDoc(arg); isDoc {
+				if sub, ok := asDoc(item); ok && !strings.HasPrefix(cond[0].Name, "$") {
+					remove, err = match(sub, cond)
+				} else {
+					remove, err = matchValue([]interface{}{item}, cond)
+				}
+			} else {
+				remove = compare(item, arg) == 0
+			}
+			if err != nil {
+				return nil, err
+			}
+			if !remove {
+				kept = append(kept, item)
+			}
+		}
+		if kept == nil {
+			kept = []interface{}{}
+		}
+		return setPath(doc, path, kept)
+	case "$pop":
+		list, err := arrayAt(old, len(current) > 0, path)
+		if err != nil || len(list) == 0 {
+			return doc, err
+		}
+		if n, _ := toFloat(arg); n < 0 {
+			list = list[1:]
+		} else {
+			list = list[:len(list)-1]
+		}
+		return setPath(doc, path, list)
+	}
+	return nil, badValue("unknown modifier: %s", op)
+}
+
+func arrayAt(v interface{}, exists bool, path string) ([]interface{}, error) {
+	if !exists || v == nil {
+		return nil, nil
+	}
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil, badValue("the field '%s' must be an array", path)
+	}
+	return append([]interface{}(nil), list...), nil
+}
+
+func arithmetic(op string, a, b interface{}) (interface{}, error) {
+	ia, aInt := asInt(a)
+	ib, bInt := asInt(b)
+	if aInt && bInt {
+		var n int64
+		if op == "$inc" {
+			n = ia + ib
+		} else {
+			n = ia * ib
+		}
+		if _, ok := a.(int64); !ok {
+			if _, ok := b.(int64); !ok && n >= -1<<31 && n < 1<<31 {
+				return int(n), nil
+			}
+		}
+		return n, nil
+	}
+	fa, aNum := toFloat(a)
+	fb, bNum := toFloat(b)
+	if !aNum || !bNum {
+		return nil, badValue("cannot apply %s to a value of non-numeric type", op)
+	}
+	if op == "$inc" {
+		return fa + fb, nil
+	}
+	return fa * fb, nil
+}
+
+func asInt(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), true
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	}
+	return 0, false
+}
+
+// setPath sets the value at a dotted path, creating intermediate documents.
+func setPath(doc bson.D, path string, v interface{}) (bson.D, error) {
+	parts := strings.SplitN(path, ".", 2)
+	if len(parts) == 1 {
+		return setField(doc, path, v), nil
+	}
+	var child interface{}
+	for _, e := range doc {
+		if e.Name == parts[0] {
+			child = e.Value
+		}
+	}
+	switch c := child.(type) {
+	case nil:
+		sub, err := setPath(bson.D{}, parts[1], v)
+		if err != nil {
+			return nil, err
+		}
+		return setField(doc, parts[0], sub), nil
+	case []interface{}:
+		rest := strings.SplitN(parts[1], ".", 2)
+		i, err := strconv.Atoi(rest[0])
+		if err != nil || i < 0 {
+			return nil, badValue("cannot create field '%s' in element {%s: array}", rest[0], parts[0])
+		}
+		list := append([]interface{}(nil), c...)
+		for len(list) <= i {
+			list = append(list, nil)
+		}
+		if len(rest) == 1 {
+			list[i] = v
+		} else {
+			sub, _ := asDoc(list[i])
+			if sub, err = setPath(sub, rest[1], v); err != nil {
+				return nil, err
+			}
+			list[i] = sub
+		}
+		return setField(doc, parts[0], list), nil
+	}
+	sub, ok := asDoc(child)
+	if !ok {
+		return nil, badValue("cannot create field '%s' in a non-document value", parts[1])
+	}
+	sub, err := setPath(copyDoc(sub), parts[1], v)
+	if err != nil {
+		return nil, err
+	}
+	return setField(doc, parts[0], sub), nil
+}
+
+// unsetPath removes the value at a dotted path, if present.
+func unsetPath(doc bson.D, path string) bson.D {
+	parts := strings.SplitN(path, ".", 2)
+	for i, e := range doc {
+		if e.Name != parts[0] {
+			continue
+		}
+		if len(parts) == 1 {
+			return append(doc[:i:i], doc[i+1:]...)
+		}
+		if sub, ok := asDoc(e.Value); ok {
+			return setField(doc, e.Name, unsetPath(copyDoc(sub), parts[1]))
+		}
+		return doc
+	}
+	return doc
+}
+
+func setField(doc bson.D, name string, v interface{}) bson.D {
+	for i, e := range doc {
+		if e.Name == name {
+			out := append(bson.D(nil), doc...)
+			out[i].Value = v
+			return out
+		}
+	}
+	return append(doc[:len(doc):len(doc)], bson.DocElem{Name: name, Value: v})
+}
+
+func copyDoc(doc bson.D) bson.D {
+	out := make(bson.D, len(doc))
+	for i, e := range doc {
+		out[i] = bson.DocElem{Name: e.Name, Value: copyValue(e.Value)}
+	}
+	return out
+}
+
+func copyValue(v interface{}) interface{} {
+	switch x := v.(type) {
+	case bson.D, bson.M, bson.RawD:
+		doc, _ := asDoc(x)
+		return copyDoc(doc)
+	case []interface{}:
+		out := make([]interface{}, len(x))
+		for i, item := range x {
+			out[i] = copyValue(item)
+		}
+		return out
+	}
+	return v
+}
+
+// upsertBase builds the document inserted by an upsert from the equality
+// conditions of its selector.
+func upsertBase(filter bson.D) bson.D {
+	doc := bson.D{}
+	for _, e := range filter {
+		if e.Name == "$and" {
+			list, _ := e.Value.([]interface{})
+			for _, item := range list {
+				if sub, ok := asDoc(item); ok {
+					for _, se := range upsertBase(sub) {
+						doc, _ = setPath(doc, se.Name, se.Value)
+					}
+				}
+			}
+			continue
+		}
+		if strings.HasPrefix(e.Name, "$") {
+			continue
+		}
+		v := e.Value
+		if ops, ok := asDoc(v); ok && len(ops) > 0 && strings.HasPrefix(ops[0].Name, "$") {
+			if ops[0].Name != "$eq" {
+				continue
+			}
+			v = ops[0].Value
+		}
+		if d, err := setPath(doc, e.Name, copyValue(v)); err == nil {
+			doc = d
+		}
+	}
+	return doc
+}
+
+// sortDocs orders docs in place according to a sort specification such as
+// {"name": 1, "age": -1}.
+func sortDocs(docs []bson.D, spec bson.D) {
+	if len(spec) == 0 {
+		return
+	}
+	sort.SliceStable(docs, func(i, j int) bool {
+		for _, e := range spec {
+			if _, ok := asDoc(e.Value); ok {
+				continue // $meta sorts keep the natural order
+			}
+			a, b := first(lookup(docs[i], e.Name)), first(lookup(docs[j], e.Name))
+			c := compare(a, b)
+			if n, _ := toFloat(e.Value); n < 0 {
+				c = -c
+			}
+			if c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+}
+
+func first(values []interface{}) interface{} {
+	if len(values) == 0 {
+		return nil
+	}
+	return values[0]
+}
+
+// project applies a find projection to doc.
+func project(doc bson.D, spec bson.D) bson.D {
+	if len(spec) == 0 {
+		return doc
+	}
+	include := false
+	keepID := true
+	for _, e := range spec {
+		if _, ok := asDoc(e.Value); ok {
+			continue
+		}
+		if e.Name == "_id" {
+			keepID = truthy(e.Value)
+			continue
+		}
+		include = truthy(e.Value)
+	}
+	if !include {
+		out := copyDoc(doc)
+		for _, e := range spec {
+			if _, ok := asDoc(e.Value); !ok && !truthy(e.Value) {
+				out = unsetPath(out, e.Name)
+			}
+		}
+		return out
+	}
+	out := bson.D{}
+	if id := lookup(doc, "_id"); keepID && len(id) > 0 {
+		out = append(out, bson.DocElem{Name: "_id", Value: id[0]})
+	}
+	for _, e := range spec {
+		if e.Name == "_id" || !truthy(e.Value) {
+			continue
+		}
+		if _, ok := asDoc(e.Value); ok {
+			continue
+		}
+		out = includePath(out, doc, strings.Split(e.Name, "."))
+	}
+	return out
+}
+
+// includePath copies the field at path from src into dst, keeping the
+// embedded documents of arrays along the path as the server does.
+func includePath(dst, src bson.D, path []string) bson.D {
+	var v interface{}
+	found := false
+	for _, e := range src {
+		if e.Name == path[0] {
+			v, found = e.Value, true
+			break
+		}
+	}
+	if !found {
+		return dst
+	}
+	if len(path) == 1 {
+		return setField(dst, path[0], copyValue(v))
+	}
+	old := first(lookup(dst, path[0]))
+	if sub, ok := asDoc(v); ok {
+		prev, _ := asDoc(old)
+		return setField(dst, path[0], includePath(prev, sub, path[1:]))
+	}
+	list, ok := v.([]interface{})
+	if !ok {
+		return dst
+	}
+	prev, _ := old.([]interface{})
+	var out []interface{}
+	for _, item := range list {
+		sub, ok := asDoc(item)
+		if !ok {
+			continue
+		}
+		var p bson.D
+		if len(out) < len(prev) {
+			p, _ = asDoc(prev[len(out)])
+		}
+		out = append(out, includePath(p, sub, path[1:]))
+	}
+	if out == nil {
+		out = []interface{}{}
+	}
+	return setField(dst, path[0], out)
+}
@@ -0,0 +1,222 @@
+package mdbtest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// Wire protocol opcodes.
+//
+// Relevant documentation:
+//
+//     https://docs.mongodb.com/manual/reference/mongodb-wire-protocol/
+//
+const (
+	opReply       = 1
+	opQuery       = 2004
+	opKillCursors = 2007
+	opMsg         = 2013
+)
+
+const (
+	msgChecksumPresent = 1 << 0
+	msgMoreToCome      = 1 << 1
+)
+
+type header struct {
+	Length     int32
+	RequestID  int32
+	ResponseTo int32
+	OpCode     int32
+}
+
+// readMessage reads a single wire protocol message from r.
+func readMessage(r io.Reader) (header, []byte, error) {
+	var h header
+	if err := binary.Read(r, binary.LittleEndian, &h); err != nil {
+		return h, nil, err
+	}
+	if h.Length < 16 || h.Length > 48*1024*1024 {
+		return h, nil, errors.New("mdbtest: invalid message length")
+	}
+	body := make([]byte, h.Length-16)
+	_, err := io.ReadFull(r, body)
+	return h, body, err
+}
+
+// writeMessage writes a message with the given opcode and body to w.
+func writeMessage(w io.Writer, requestID, responseTo, opCode int32, body []byte) error {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, header{int32(16 + len(body)), requestID, responseTo, opCode})
+	buf.Write(body)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// queryMsg is a decoded OP_QUERY message.
+type queryMsg struct {
+	Flags      int32
+	Collection string
+	Skip       int32
+	Return     int32
+	Query      bson.D
+	Selector   bson.D
+}
+
+func parseQuery(body []byte) (*queryMsg, error) {
+	r := &reader{data: body}
+	q := &queryMsg{}
+	q.Flags = r.int32()
+	q.Collection = r.cstring()
+	q.Skip = r.int32()
+	q.Return = r.int32()
+	q.Query = r.document()
+	if r.err == nil && len(r.data) > 0 {
+		q.Selector = r.document()
+	}
+	return q, r.err
+}
+
+// parseMsg decodes an OP_MSG message into its command document, folding
+// document sequences into the command as arrays.
+func parseMsg(body []byte) (flags uint32, cmd bson.D, err error) {
+	r := &reader{data: body}
+	flags = uint32(r.int32())
+	if flags&msgChecksumPresent != 0 && len(r.data) >= 4 {
+		r.data = r.data[:len(r.data)-4]
+	}
+	for r.err == nil && len(r.data) > 0 {
+		switch kind := r.byte(); kind {
+		case 0:
+			cmd = append(r.document(), cmd...)
+		case 1:
+			size := int(r.int32())
+			if size < 4 || size-4 > len(r.data) {
+				return 0, nil, errors.New("mdbtest: invalid document sequence")
+			}
+			seq := &reader{data: r.data[:size-4]}
+			r.data = r.data[size-4:]
+			name := seq.cstring()
+			var docs []interface{}
+			for seq.err == nil && len(seq.data) > 0 {
+				docs = append(docs, seq.document())
+			}
+			if seq.err != nil {
+				return 0, nil, seq.err
+			}
+			cmd = append(cmd, bson.DocElem{Name: name, Value: docs})
+		default:
+			return 0, nil, errors.New("mdbtest: unknown OP_MSG section kind")
+		}
+	}
+	return flags, cmd, r.err
+}
+
+// replyBody encodes an OP_REPLY body holding docs.
+func replyBody(cursorID int64, docs ...interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, int32(0))
+	binary.Write(&buf, binary.LittleEndian, cursorID)
+	binary.Write(&buf, binary.LittleEndian, int32(0))
+	binary.Write(&buf, binary.LittleEndian, int32(len(docs)))
+	for _, doc := range docs {
+		data, err := bson.Marshal(doc)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(data)
+	}
+	return buf.Bytes(), nil
+}
+
+// msgBody encodes an OP_MSG body with a single document section.
+func msgBody(doc interface{}) ([]byte, error) {
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	body := make([]byte, 5, 5+len(data))
+	return append(body, data...), nil
+}
+
+// reader decodes little-endian wire protocol fields, remembering the first
+// error found.
+type reader struct {
+	data []byte
+	err  error
+}
+
+var errShortMessage = errors.New("mdbtest: message too short")
+
+func (r *reader) byte() byte {
+	if r.err != nil || len(r.data) < 1 {
+		r.fail()
+		return 0
+	}
+	b := r.data[0]
+	r.data = r.data[1:]
+	return b
+}
+
+func (r *reader) int32() int32 {
+	if r.err != nil || len(r.data) < 4 {
+		r.fail()
+		return 0
+	}
+	n := int32(binary.LittleEndian.Uint32(r.data))
+	r.data = r.data[4:]
+	return n
+}
+
+func (r *reader) int64() int64 {
+	if r.err != nil || len(r.data) < 8 {
+		r.fail()
+		return 0
+	}
+	n := int64(binary.LittleEndian.Uint64(r.data))
+	r.data = r.data[8:]
+	return n
+}
+
+func (r *reader) cstring() string {
+	if r.err != nil {
+		return ""
+	}
+	i := bytes.IndexByte(r.data, 0)
+	if i < 0 {
+		r.fail()
+		return ""
+	}
+	s := string(r.data[:i])
+	r.data = r.data[i+1:]
+	return s
+}
+
+func (r *reader) document() bson.D {
+	if r.err != nil || len(r.data) < 4 {
+		r.fail()
+		return nil
+	}
+	size := int(binary.LittleEndian.Uint32(r.data))
+	if size < 5 || size > len(r.data) {
+		r.fail()
+		return nil
+	}
+	var doc bson.D
+	if err := bson.Unmarshal(r.data[:size], &doc); err != nil {
+		r.err = err
+		return nil
+	}
+	r.data = r.data[size:]
+	return doc
+}
+
+func (r *reader) fail() {
+	if r.err == nil {
+		r.err = errShortMessage
+	}
+}
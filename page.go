@@ -0,0 +1,86 @@
+package mdb
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+)
+
+// PageInfo describes a page returned by Query.Page.
+type PageInfo struct {
+	Page    int  // Number of the page, from 1.
+	Size    int  // Maximum number of documents in a page.
+	Total   int  // Documents matching the query, up to the MaxCount cap.
+	Pages   int  // Pages holding Total documents.
+	HasNext bool // Whether a following page holds documents.
+	Capped  bool // Whether counting stopped at the MaxCount cap.
+}
+
+// MaxCount caps the number of documents counted by Page, so that pages of
+// queries matching a large number of documents stay fast. Counts reaching
+// the cap are reported with PageInfo.Capped set.
+func (q *Query) MaxCount(n int) *Query {
+	q.maxCount = n
+	return q
+}
+
+// Page stores into result, which must be a pointer to a slice, the page-th
+// page of size documents of the query, counting pages from 1, and returns
+// the total number of documents and pages. The documents and the count
+// are queried concurrently, and both are retried on network errors.
+//
+// Both queries are copies with the filter, sort, projection and batch size
+// of q, which is left unchanged.
+//
+// Pages are found by skipping documents, which gets slower deep into large
+// result sets; see Paginate for an alternative. The query should be
+// sorted so that pages are stable.
+//
+// For example:
+//
+//     var people []Person
+//     info, err := c.Find(nil).Sort("name").MaxCount(10000).Page(3, 20, &people)
+//
+func (q *Query) Page(page, size int, result interface{}) (*PageInfo, error) {
+	resultv := reflect.ValueOf(result)
+	if resultv.Kind() != reflect.Ptr || resultv.Elem().Kind() != reflect.Slice {
+		panic("result argument must be a slice address")
+	}
+	if page < 1 || size < 1 {
+		return nil, errors.New("mdb: page and size must be positive")
+	}
+	count := &Query{db: q.db, col: q.col, q: q.col.col.Find(q.filter), filter: q.filter}
+	if q.maxCount > 0 {
+		count.q.Limit(q.maxCount)
+	}
+	info := &PageInfo{Page: page, Size: size}
+	var wg sync.WaitGroup
+	var countErr error
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		info.Total, countErr = count.Count()
+	}()
+	docs := &Query{db: q.db, col: q.col, q: q.col.col.Find(q.filter), filter: q.filter}
+	if q.sort != nil {
+		docs.Sort(q.sort...)
+	}
+	if q.selector != nil {
+		docs.Select(q.selector)
+	}
+	if q.batch > 0 {
+		docs.Batch(q.batch)
+	}
+	err := docs.Skip((page - 1) * size).Limit(size).All(result)
+	wg.Wait()
+	if err == nil {
+		err = countErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	info.Capped = q.maxCount > 0 && info.Total >= q.maxCount
+	info.Pages = (info.Total + size - 1) / size
+	info.HasNext = page*size < info.Total || info.Capped && resultv.Elem().Len() == size
+	return info, nil
+}
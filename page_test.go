@@ -0,0 +1,66 @@
+package mdb
+
+import (
+	"testing"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+func TestPage(t *testing.T) {
+	srv, db := dialTest(t)
+	c := db.C("people")
+	for i := 0; i < 7; i++ {
+		if err := c.Insert(bson.M{"_id": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var people []struct {
+		Id int `bson:"_id"`
+	}
+	info, err := c.Find(nil).Sort("-_id").Page(2, 3, &people)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *info != (PageInfo{Page: 2, Size: 3, Total: 7, Pages: 3, HasNext: true}) || len(people) != 3 || people[0].Id != 3 {
+		t.Fatalf("page 2: %+v %v", info, people)
+	}
+	info, err = c.Find(bson.M{"_id": bson.M{"$gte": 1}}).Sort("_id").Page(2, 3, &people)
+	if err != nil || info.Total != 6 || info.HasNext || len(people) != 3 || people[2].Id != 6 {
+		t.Fatalf("last page: %+v %v %v", info, people, err)
+	}
+	info, err = c.Find(nil).Sort("_id").MaxCount(4).Page(2, 3, &people)
+	if err != nil || info.Total != 4 || !info.Capped || info.Pages != 2 || !info.HasNext {
+		t.Fatalf("capped count: %+v %v", info, err)
+	}
+	q := c.Find(nil).Sort("_id").Select(bson.M{"_id": 1})
+	for page := 1; page <= 3; page++ {
+		if info, err = q.Page(page, 3, &people); err != nil || len(people) == 0 || people[0].Id != (page-1)*3 {
+			t.Fatalf("page %d of a reused query: %+v %v %v", page, info, people, err)
+		}
+	}
+	var all []bson.M
+	if err := q.All(&all); err != nil || len(all) != 7 {
+		t.Fatalf("query changed by Page: %d documents, %v", len(all), err)
+	}
+	if _, err := c.Find(nil).Page(0, 3, &people); err == nil {
+		t.Fatal("page 0 accepted")
+	}
+
+	srv.DropNext("count", 1)
+	faults := NewFaultInjector()
+	faults.FailNext("people", OpAll, 1, ErrFaultEOF)
+	db.SetFaultInjector(faults)
+	info, err = c.Find(nil).Sort("_id").Page(3, 3, &people)
+	if err != nil || info.Total != 7 || len(people) != 1 {
+		t.Fatalf("retried page: %+v %v %v", info, people, err)
+	}
+	if n := srv.Received("count"); n < 2 {
+		t.Fatalf("count received %d times", n)
+	}
+
+	copied := db.Copy()
+	defer copied.Close()
+	if n, err := copied.C("people").Find(nil).Count(); err != nil || n != 7 {
+		t.Fatalf("count on a copied database = %d, %v; want 7", n, err)
+	}
+}
@@ -0,0 +1,308 @@
+package mdb
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// ErrInvalidPageToken is returned by Query.Paginate for page tokens which
+// were not issued for the same collection, query document and sort, or
+// were modified.
+var ErrInvalidPageToken = errors.New("mdb: invalid page token")
+
+// KeysetPage holds the tokens of the pages around the one returned by
+// Query.Paginate. A token is empty when there is no such page.
+type KeysetPage struct {
+	Next     string
+	Previous string
+}
+
+// Paginate stores into result, which must be a pointer to a slice, a page
+// of at most pageSize documents of the query ordered by sortFields, which
+// are given as for Query.Sort. The empty token requests the first page;
+// other pages are requested with the tokens of the returned KeysetPage.
+//
+// Pages are found with a range filter starting after the sort key of the
+// last document seen, rather than by skipping documents, so they are
+// equally fast deep into large collections. _id is added to the sort
+// fields to break ties, and every document should hold the sort fields.
+// The fields selected with Select must include them too.
+//
+// Tokens are encrypted and authenticated with the key set by
+// SetPageTokenKey, so they can be handed to clients without revealing the
+// sort keys they hold, and are only accepted by queries on the same
+// collection with the same query document and sort. Only the query
+// document and selector are kept from q.
+//
+// For example:
+//
+//     var people []Person
+//     page, err := c.Find(bson.M{"active": true}).Paginate([]string{"-joined"}, 20, token, &people)
+//     // page.Next is the token of the next 20 people
+//
+func (q *Query) Paginate(sortFields []string, pageSize int, token string, result interface{}) (*KeysetPage, error) {
+	resultv := reflect.ValueOf(result)
+	if resultv.Kind() != reflect.Ptr || resultv.Elem().Kind() != reflect.Slice {
+		panic("result argument must be a slice address")
+	}
+	if pageSize <= 0 {
+		return nil, errors.New("mdb: page size must be positive")
+	}
+	sort := sortDoc(sortFields)
+	if !hasField(sort, "_id") {
+		sort = append(sort, bson.DocElem{Name: "_id", Value: 1})
+	}
+	bound, err := pageTokenBinding(q.col.Name, q.filter)
+	if err != nil {
+		return nil, err
+	}
+	var key []interface{}
+	backward := false
+	if token != "" {
+		t, err := q.db.decodePageToken(token, sort, bound)
+		if err != nil {
+			return nil, err
+		}
+		key, backward = t.Key, t.Backward
+	}
+
+	filter := q.filter
+	if key != nil {
+		after := keysetFilter(sort, key, backward)
+		if filter == nil {
+			filter = after
+		} else {
+			filter = bson.D{{Name: "$and", Value: []interface{}{filter, after}}}
+		}
+	}
+	page := &Query{db: q.db, col: q.col, q: q.col.col.Find(filter), filter: filter}
+	if q.selector != nil {
+		page.Select(q.selector)
+	}
+	var docs []bson.Raw
+	if err := page.Sort(keysetSort(sort, backward)...).Limit(pageSize + 1).All(&docs); err != nil {
+		return nil, err
+	}
+	more := len(docs) > pageSize
+	if more {
+		docs = docs[:pageSize]
+	}
+	if backward {
+		for i, j := 0, len(docs)-1; i < j; i, j = i+1, j-1 {
+			docs[i], docs[j] = docs[j], docs[i]
+		}
+	}
+
+	slice := reflect.MakeSlice(resultv.Elem().Type(), len(docs), len(docs))
+	for i, doc := range docs {
+		if err := doc.Unmarshal(slice.Index(i).Addr().Interface()); err != nil {
+			return nil, err
+		}
+	}
+	resultv.Elem().Set(slice)
+	if err := afterFind(result); err != nil {
+		return nil, err
+	}
+
+	p := &KeysetPage{}
+	if len(docs) == 0 {
+		return p, nil
+	}
+	if more || backward {
+		if p.Next, err = q.db.encodePageToken(sort, docs[len(docs)-1], false, bound); err != nil {
+			return nil, err
+		}
+	}
+	if more && backward || !backward && token != "" {
+		if p.Previous, err = q.db.encodePageToken(sort, docs[0], true, bound); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// keysetSort returns the fields of sort as given to Query.Sort, reversing
+// their order when backward is set.
+func keysetSort(sort bson.D, backward bool) []string {
+	fields := make([]string, len(sort))
+	for i, e := range sort {
+		fields[i] = e.Name
+		if (e.Value == -1) != backward {
+			fields[i] = "-" + e.Name
+		}
+	}
+	return fields
+}
+
+// keysetFilter matches the documents after key in the sort order, or
+// before it when backward is set:
+//
+//     {$or: [{a: {$gt: ka}}, {a: ka, b: {$gt: kb}}, ...]}
+//
+func keysetFilter(sort bson.D, key []interface{}, backward bool) bson.D {
+	var or []interface{}
+	for i, e := range sort {
+		cond := bson.D{}
+		for j := 0; j < i; j++ {
+			cond = append(cond, bson.DocElem{Name: sort[j].Name, Value: key[j]})
+		}
+		op := "$gt"
+		if (e.Value == -1) != backward {
+			op = "$lt"
+		}
+		cond = append(cond, bson.DocElem{Name: e.Name, Value: bson.D{{Name: op, Value: key[i]}}})
+		or = append(or, cond)
+	}
+	return bson.D{{Name: "$or", Value: or}}
+}
+
+// pageToken is the content of a page token.
+type pageToken struct {
+	Sort     bson.D        `bson:"s"`
+	Key      []interface{} `bson:"k"`
+	Backward bool          `bson:"b,omitempty"`
+}
+
+// encodePageToken returns the token of the page after doc, or before it if
+// backward is set, for the query identified by bound.
+func (db *Database) encodePageToken(sort bson.D, doc bson.Raw, backward bool, bound []byte) (string, error) {
+	key, err := keysetKey(sort, doc)
+	if err != nil {
+		return "", err
+	}
+	data, err := bson.Marshal(&pageToken{Sort: sort, Key: key, Backward: backward})
+	if err != nil {
+		return "", err
+	}
+	aead, err := db.pageTokenCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	data = aead.Seal(nonce, nonce, data, bound)
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// decodePageToken checks and decodes a token issued for sort and the query
+// identified by bound.
+func (db *Database) decodePageToken(token string, sort bson.D, bound []byte) (*pageToken, error) {
+	aead, err := db.pageTokenCipher()
+	if err != nil {
+		return nil, err
+	}
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(data) < aead.NonceSize() {
+		return nil, ErrInvalidPageToken
+	}
+	data, err = aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], bound)
+	if err != nil {
+		return nil, ErrInvalidPageToken
+	}
+	var t pageToken
+	if err := bson.Unmarshal(data, &t); err != nil || len(t.Key) != len(sort) || len(t.Sort) != len(sort) {
+		return nil, ErrInvalidPageToken
+	}
+	for i, e := range sort {
+		order, _ := t.Sort[i].Value.(int)
+		if t.Sort[i].Name != e.Name || order != e.Value {
+			return nil, ErrInvalidPageToken
+		}
+	}
+	return &t, nil
+}
+
+// pageTokenCipher returns the AES-GCM cipher of page tokens, keyed with a
+// hash of the page token key.
+func (db *Database) pageTokenCipher() (cipher.AEAD, error) {
+	key := db.pageKey
+	if key == nil {
+		key = defaultPageKey()
+	}
+	sum := sha256.Sum256(key)
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// pageTokenBinding returns a digest of the collection and query document a
+// page token is issued for. Fields are compared regardless of their order.
+func pageTokenBinding(collection string, filter interface{}) ([]byte, error) {
+	m := bson.M{}
+	if filter != nil {
+		data, err := bson.Marshal(filter)
+		if err != nil {
+			return nil, err
+		}
+		if err := bson.Unmarshal(data, &m); err != nil {
+			return nil, err
+		}
+	}
+	var b strings.Builder
+	b.WriteString(collection)
+	b.WriteByte(0)
+	writeJSON(&b, m)
+	sum := sha256.Sum256([]byte(b.String()))
+	return sum[:], nil
+}
+
+// SetPageTokenKey sets the key encrypting the page tokens of Query.Paginate.
+// By default a random key is made for the process, so tokens are only
+// valid within it: processes sharing clients must share a key.
+func (db *Database) SetPageTokenKey(key []byte) {
+	db.pageKey = append([]byte(nil), key...)
+}
+
+var (
+	pageKeyOnce sync.Once
+	pageKey     []byte
+)
+
+func defaultPageKey() []byte {
+	pageKeyOnce.Do(func() {
+		pageKey = make([]byte, 32)
+		if _, err := rand.Read(pageKey); err != nil {
+			panic("mdb: can't make page token key: " + err.Error())
+		}
+	})
+	return pageKey
+}
+
+// keysetKey returns the values of the sort fields of doc.
+func keysetKey(sort bson.D, doc bson.Raw) ([]interface{}, error) {
+	var m bson.M
+	if err := doc.Unmarshal(&m); err != nil {
+		return nil, err
+	}
+	key := make([]interface{}, len(sort))
+	for i, e := range sort {
+		key[i] = pathValue(m, e.Name)
+	}
+	return key, nil
+}
+
+// pathValue returns the value at the dotted path of m, or nil.
+func pathValue(m bson.M, path string) interface{} {
+	var v interface{} = m
+	for _, name := range strings.Split(path, ".") {
+		doc, ok := v.(bson.M)
+		if !ok {
+			return nil
+		}
+		v = doc[name]
+	}
+	return v
+}
@@ -0,0 +1,107 @@
+package mdb
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+func TestPaginate(t *testing.T) {
+	_, db := dialTest(t)
+	c := db.C("people")
+	for i := 0; i < 10; i++ {
+		doc := bson.M{"_id": i, "age": 20 + i%3, "name": string(rune('a' + i)), "active": i != 4}
+		if err := c.Insert(doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	type person struct {
+		Id  int `bson:"_id"`
+		Age int
+	}
+	ids := func(people []person) string {
+		var b strings.Builder
+		for _, p := range people {
+			b.WriteByte(byte('0' + p.Id))
+		}
+		return b.String()
+	}
+
+	// Ordered by age descending, then _id: 2 5 8 | 1 7 0 | 3 6 9, without 4.
+	sortFields := []string{"-age"}
+	var people []person
+	var pages []string
+	var tokens []*KeysetPage
+	token := ""
+	for {
+		page, err := c.Find(bson.M{"active": true}).Paginate(sortFields, 3, token, &people)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, ids(people))
+		tokens = append(tokens, page)
+		if page.Next == "" {
+			break
+		}
+		token = page.Next
+	}
+	if got := strings.Join(pages, " "); got != "258 170 369" {
+		t.Fatalf("pages %s", got)
+	}
+	if tokens[0].Previous != "" || tokens[1].Previous == "" {
+		t.Fatalf("previous tokens: %+v %+v", tokens[0], tokens[1])
+	}
+
+	page, err := c.Find(bson.M{"active": true}).Paginate(sortFields, 3, tokens[2].Previous, &people)
+	if err != nil || ids(people) != "170" || page.Next == "" || page.Previous == "" {
+		t.Fatalf("previous page: %s %+v %v", ids(people), page, err)
+	}
+	page, err = c.Find(bson.M{"active": true}).Paginate(sortFields, 3, page.Previous, &people)
+	if err != nil || ids(people) != "258" || page.Previous != "" || page.Next == "" {
+		t.Fatalf("first page again: %s %+v %v", ids(people), page, err)
+	}
+
+	page, err = c.Find(nil).Paginate([]string{"name"}, 4, "", &people)
+	if err != nil || ids(people) != "0123" {
+		t.Fatalf("by name: %s %v", ids(people), err)
+	}
+	if _, err := c.Find(nil).Paginate([]string{"age"}, 4, page.Next, &people); err != ErrInvalidPageToken {
+		t.Fatalf("token of another sort: %v", err)
+	}
+	if _, err := c.Find(bson.M{"active": true}).Paginate([]string{"name"}, 4, page.Next, &people); err != ErrInvalidPageToken {
+		t.Fatalf("token of another query: %v", err)
+	}
+	if _, err := db.C("others").Find(nil).Paginate([]string{"name"}, 4, page.Next, &people); err != ErrInvalidPageToken {
+		t.Fatalf("token of another collection: %v", err)
+	}
+	if raw, err := base64.RawURLEncoding.DecodeString(page.Next); err != nil || bytes.Contains(raw, []byte("name")) {
+		t.Fatalf("token content readable: %q %v", raw, err)
+	}
+	filter := bson.M{"active": true, "age": bson.M{"$gte": 20}}
+	page, err = c.Find(filter).Paginate([]string{"name"}, 2, "", &people)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reordered := bson.D{{Name: "age", Value: bson.D{{Name: "$gte", Value: 20}}}, {Name: "active", Value: true}}
+	if _, err := c.Find(reordered).Paginate([]string{"name"}, 2, page.Next, &people); err != nil || ids(people) != "23" {
+		t.Fatalf("token of the same query with fields in another order: %s %v", ids(people), err)
+	}
+	page, err = c.Find(nil).Paginate([]string{"name"}, 4, "", &people)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := []byte(page.Next)
+	tampered[5] ^= 1
+	if _, err := c.Find(nil).Paginate([]string{"name"}, 4, string(tampered), &people); err != ErrInvalidPageToken {
+		t.Fatalf("tampered token: %v", err)
+	}
+	other := db.DB("test")
+	other.SetPageTokenKey([]byte("secret"))
+	if _, err := other.C("people").Find(nil).Paginate([]string{"name"}, 4, page.Next, &people); err != ErrInvalidPageToken {
+		t.Fatalf("token signed with another key: %v", err)
+	}
+}
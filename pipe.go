@@ -0,0 +1,137 @@
+package mdb
+
+import (
+	"time"
+
+	"github.com/globalsign/mgo"
+)
+
+// Pipe is an aggregation prepared by Collection.Pipe. Like Query, it runs
+// the aggregation again after refreshing the session when the connection
+// breaks.
+type Pipe struct {
+	p   *mgo.Pipe
+	db  *Database
+	col *Collection
+}
+
+// Iter executes the pipeline and returns an iterator capable of going
+// over all the generated results.
+//
+// Only running the aggregation is retried: an error while fetching further
+// batches is reported by the iterator, as with Query.Iter.
+func (p *Pipe) Iter() *Iter {
+	var err error
+	for i := 0; i < p.db.MaxConnectRetries; i++ {
+		if err = p.db.fault(p.col.Name, OpPipe); err == nil {
+			it := p.p.Iter()
+			if err = it.Err(); err == nil {
+				return &Iter{i: it, db: p.db, col: p.col}
+			}
+		}
+		if !isNetworkError(err) {
+			break
+		}
+		p.db.refresh()
+	}
+	return &Iter{db: p.db, col: p.col, err: err}
+}
+
+// All works like Iter.All.
+func (p *Pipe) All(result interface{}) (err error) {
+	for i := 0; i < p.db.MaxConnectRetries; i++ {
+		if err = p.db.fault(p.col.Name, OpPipe); err == nil {
+			err = p.p.All(result)
+		}
+		if err == nil {
+			return afterFind(result)
+		}
+		if !isNetworkError(err) {
+			return
+		}
+		p.db.refresh()
+	}
+	return err
+}
+
+// One executes the pipeline and unmarshals the first item from the
+// result set into the result parameter.
+// It returns ErrNotFound if no items are generated by the pipeline.
+func (p *Pipe) One(result interface{}) (err error) {
+	for i := 0; i < p.db.MaxConnectRetries; i++ {
+		if err = p.db.fault(p.col.Name, OpPipe); err == nil {
+			err = p.p.One(result)
+		}
+		if err == nil {
+			return afterFind(result)
+		}
+		if !isNetworkError(err) {
+			return
+		}
+		p.db.refresh()
+	}
+	return err
+}
+
+// Explain returns a number of details about how the MongoDB server would
+// execute the requested pipeline, such as the number of objects examined,
+// the number of times the read lock was yielded to allow writes to go in,
+// and so on.
+//
+// For example:
+//
+//     var m bson.M
+//     err := collection.Pipe(pipeline).Explain(&m)
+//     if err == nil {
+//         fmt.Printf("Explain: %#v\n", m)
+//     }
+//
+func (p *Pipe) Explain(result interface{}) (err error) {
+	for i := 0; i < p.db.MaxConnectRetries; i++ {
+		if err = p.db.fault(p.col.Name, OpPipe); err == nil {
+			err = p.p.Explain(result)
+		}
+		if !isNetworkError(err) {
+			return
+		}
+		p.db.refresh()
+	}
+	return err
+}
+
+// AllowDiskUse enables writing to the "<dbpath>/_tmp" server directory so
+// that aggregation pipelines do not have to be held entirely in memory.
+func (p *Pipe) AllowDiskUse() *Pipe {
+	p.p = p.p.AllowDiskUse()
+	return p
+}
+
+// Batch sets the batch size used when fetching documents from the database.
+// It's possible to change this setting on a per-session basis as well, using
+// the Batch method of Session.
+//
+// The default batch size is defined by the database server.
+func (p *Pipe) Batch(n int) *Pipe {
+	p.p = p.p.Batch(n)
+	return p
+}
+
+// SetMaxTime sets the maximum amount of time to allow the query to run.
+func (p *Pipe) SetMaxTime(d time.Duration) *Pipe {
+	p.p = p.p.SetMaxTime(d)
+	return p
+}
+
+// Collation allows to specify language-specific rules for string comparison,
+// such as rules for lettercase and accent marks.
+// When specifying collation, the locale field is mandatory; all other collation
+// fields are optional
+//
+// Relevant documentation:
+//
+//     https://docs.mongodb.com/manual/reference/collation/
+//
+func (p *Pipe) Collation(collation *mgo.Collation) *Pipe {
+	p.p = p.p.Collation(collation)
+	return p
+}
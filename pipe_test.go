@@ -0,0 +1,92 @@
+package mdb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+func TestPipeRetries(t *testing.T) {
+	srv, db := dialTest(t)
+	c := db.C("people")
+	for i := 0; i < 5; i++ {
+		if err := c.Insert(bson.M{"_id": i, "age": i * 10}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p := NewPipeline().Match(Where("age").Gte(20)).Sort("-age")
+
+	srv.DropNext("aggregate", 1)
+	var all []bson.M
+	if err := c.Pipe(p).AllowDiskUse().SetMaxTime(time.Minute).All(&all); err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 3 || all[0]["age"] != 40 {
+		t.Fatalf("all: %v", all)
+	}
+	if n := srv.Received("aggregate"); n != 2 {
+		t.Fatalf("aggregate received %d times, want 2", n)
+	}
+
+	faults := NewFaultInjector()
+	faults.FailNext("people", OpPipe, 1, ErrFaultEOF)
+	db.SetFaultInjector(faults)
+	var one bson.M
+	if err := c.Pipe(p).One(&one); err != nil || one["_id"] != 4 {
+		t.Fatalf("one: %v %v", one, err)
+	}
+	if err := c.Pipe(NewPipeline().Match(Where("age").Gt(100))).One(&one); err != mgo.ErrNotFound {
+		t.Fatalf("one of nothing: %v", err)
+	}
+
+	faults.FailNext("people", OpPipe, 1, ErrFaultClosed)
+	iter := c.Pipe(p).Batch(1).Iter()
+	var n int
+	for iter.Next(&one) {
+		n++
+	}
+	if err := iter.Close(); err != nil || n != 3 {
+		t.Fatalf("iterated %d documents, %v; want 3", n, err)
+	}
+
+	faults.FailNext("people", OpPipe, 1, FaultServerError(2, "bad pipeline"))
+	iter = c.Pipe(p).Iter()
+	if iter.Next(&one) || iter.Close() == nil {
+		t.Fatal("server error not reported by the iterator")
+	}
+	if n := faults.Hits(OpPipe); n != 3 {
+		t.Fatalf("hits = %d, want 3", n)
+	}
+
+	faults.FailNext("people", OpPipe, db.MaxConnectRetries, ErrFaultEOF)
+	iter = c.Pipe(p).Iter()
+	if iter.Next(&one) || !iter.Done() || iter.Timeout() || iter.Err() != ErrFaultEOF {
+		t.Fatalf("iterator of a failed aggregation: %v", iter.Err())
+	}
+	if err := iter.All(&all); err != ErrFaultEOF {
+		t.Fatalf("all of a failed aggregation: %v", err)
+	}
+	if err := iter.Close(); err != ErrFaultEOF {
+		t.Fatalf("close of a failed aggregation: %v", err)
+	}
+
+	var explain bson.M
+	if err := c.Pipe(p).Explain(&explain); err != nil || explain["stages"] == nil {
+		t.Fatalf("explain: %v %v", explain, err)
+	}
+
+	copied := db.Copy()
+	defer copied.Close()
+	all = nil
+	if err := copied.C("people").Pipe(p).All(&all); err != nil || len(all) != 3 {
+		t.Fatalf("all on a copied database: %v %v", all, err)
+	}
+	iter = copied.C("people").Pipe(p).Iter()
+	for n = 0; iter.Next(&one); n++ {
+	}
+	if err := iter.Close(); err != nil || n != 3 {
+		t.Fatalf("iterated %d documents on a copied database, %v; want 3", n, err)
+	}
+}
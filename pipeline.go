@@ -0,0 +1,378 @@
+package mdb
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// Pipeline is an aggregation pipeline built stage by stage, which may be
+// passed to Collection.Pipe. Stages are checked as they are added: $out and
+// $merge must be the last stage, and facets may not nest $facet, $out or
+// $merge. Err returns the first problem found, and Pipe fails with it.
+//
+// For example, the total amount of the paid orders of each customer:
+//
+//     p := mdb.NewPipeline().
+//         Match(mdb.Where("status").Eq("paid")).
+//         Group("$customer", mdb.As("total").Sum("$amount"), mdb.As("orders").Count()).
+//         Sort("-total").
+//         Limit(10)
+//     err := c.Pipe(p).All(&top)
+//
+// Pipelines are values: adding stages returns a new Pipeline and leaves the
+// original unchanged.
+type Pipeline struct {
+	stages []bson.M
+	err    error
+}
+
+// NewPipeline returns an empty pipeline.
+func NewPipeline() Pipeline {
+	return Pipeline{}
+}
+
+// Stages returns the stages of the pipeline.
+func (p Pipeline) Stages() []bson.M {
+	if p.stages == nil {
+		return []bson.M{}
+	}
+	return p.stages
+}
+
+// Err returns the first problem found while building the pipeline.
+func (p Pipeline) Err() error {
+	return p.err
+}
+
+// GetBSON implements bson.Getter, so that a Pipeline marshals as its stages,
+// or fails with its problem.
+func (p Pipeline) GetBSON() (interface{}, error) {
+	return p.Stages(), p.err
+}
+
+// String returns the stages as Extended JSON.
+func (p Pipeline) String() string {
+	var b strings.Builder
+	b.WriteByte('[')
+	for i, stage := range p.stages {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		for name, value := range stage {
+			writeJSON(&b, bson.D{{Name: name, Value: value}})
+		}
+	}
+	b.WriteByte(']')
+	return b.String()
+}
+
+// Match filters documents with filter, which may be a Filter or a query
+// document.
+func (p Pipeline) Match(filter interface{}) Pipeline {
+	if f, ok := filter.(Filter); ok {
+		if err := f.Err(); err != nil {
+			return p.fail("$match: %v", err)
+		}
+		filter = f.D()
+	}
+	if filter == nil {
+		filter = bson.D{}
+	}
+	return p.stage("$match", filter)
+}
+
+// Group groups documents by the id expression, such as "$customer" or nil
+// for a single group, computing the accumulators of each group.
+func (p Pipeline) Group(id interface{}, accumulators ...Accumulator) Pipeline {
+	group := bson.D{{Name: "_id", Value: id}}
+	for _, acc := range accumulators {
+		if acc.field == "_id" || acc.field == "" {
+			return p.fail("$group accumulator needs a field name other than _id")
+		}
+		group = append(group, bson.DocElem{Name: acc.field, Value: acc.expr})
+	}
+	return p.stage("$group", group)
+}
+
+// Project reshapes documents with a projection document.
+func (p Pipeline) Project(spec interface{}) Pipeline {
+	return p.stage("$project", spec)
+}
+
+// AddFields adds fields computed by the expressions of spec.
+func (p Pipeline) AddFields(spec interface{}) Pipeline {
+	return p.stage("$addFields", spec)
+}
+
+// Lookup joins the documents of collection from whose foreignField equals
+// localField, storing them in the array field as.
+func (p Pipeline) Lookup(from, localField, foreignField, as string) Pipeline {
+	if from == "" || localField == "" || foreignField == "" || as == "" {
+		return p.fail("$lookup needs from, localField, foreignField and as")
+	}
+	return p.stage("$lookup", bson.D{
+		{Name: "from", Value: from},
+		{Name: "localField", Value: localField},
+		{Name: "foreignField", Value: foreignField},
+		{Name: "as", Value: as},
+	})
+}
+
+// Unwind outputs a document per element of the array field at path, such
+// as "$items". With preserveEmpty set, documents whose array is missing,
+// null or empty are output too.
+func (p Pipeline) Unwind(path string, preserveEmpty bool) Pipeline {
+	if !strings.HasPrefix(path, "$") {
+		return p.fail("$unwind path %q must start with $", path)
+	}
+	if !preserveEmpty {
+		return p.stage("$unwind", path)
+	}
+	return p.stage("$unwind", bson.D{{Name: "path", Value: path}, {Name: "preserveNullAndEmptyArrays", Value: true}})
+}
+
+// Sort orders documents by fields, which may be prefixed by - (minus) for
+// descending order, as with Query.Sort.
+func (p Pipeline) Sort(fields ...string) Pipeline {
+	spec := sortDoc(fields)
+	if len(spec) == 0 {
+		return p.fail("$sort needs at least a field")
+	}
+	return p.stage("$sort", spec)
+}
+
+// Skip skips the first n documents.
+func (p Pipeline) Skip(n int) Pipeline {
+	if n < 0 {
+		return p.fail("$skip must not be negative")
+	}
+	return p.stage("$skip", n)
+}
+
+// Limit passes on the first n documents only.
+func (p Pipeline) Limit(n int) Pipeline {
+	if n <= 0 {
+		return p.fail("$limit must be positive")
+	}
+	return p.stage("$limit", n)
+}
+
+// Count outputs a single document holding the number of documents in field.
+func (p Pipeline) Count(field string) Pipeline {
+	if field == "" || strings.HasPrefix(field, "$") || strings.Contains(field, ".") {
+		return p.fail("$count field %q is invalid", field)
+	}
+	return p.stage("$count", field)
+}
+
+// Facet runs each sub-pipeline on the same input documents, outputting a
+// single document with the results of each in the field named after it.
+func (p Pipeline) Facet(facets map[string]Pipeline) Pipeline {
+	spec := bson.M{}
+	for name, sub := range facets {
+		if sub.err != nil {
+			return p.fail("facet %s: %v", name, sub.err)
+		}
+		for _, stage := range sub.stages {
+			for op := range stage {
+				if op == "$facet" || op == "$out" || op == "$merge" {
+					return p.fail("facet %s: %s is not allowed within $facet", name, op)
+				}
+			}
+		}
+		spec[name] = sub.Stages()
+	}
+	return p.stage("$facet", spec)
+}
+
+// Bucket groups documents by the value of the groupBy expression into the
+// buckets between consecutive boundaries, which must be sorted. Documents
+// outside the boundaries go into the bucket with the def id, if not nil.
+// Without accumulators, buckets count their documents.
+func (p Pipeline) Bucket(groupBy interface{}, boundaries []interface{}, def interface{}, accumulators ...Accumulator) Pipeline {
+	if len(boundaries) < 2 {
+		return p.fail("$bucket needs at least two boundaries")
+	}
+	for i := 1; i < len(boundaries); i++ {
+		a, b := boundaries[i-1], boundaries[i]
+		less, ok := boundaryLess(a, b)
+		if !ok && reflect.TypeOf(a) != reflect.TypeOf(b) {
+			return p.fail("$bucket boundaries %v and %v are of different types", a, b)
+		}
+		if ok && !less {
+			return p.fail("$bucket boundaries must be sorted in ascending order, found %v before %v", a, b)
+		}
+	}
+	spec := bson.D{{Name: "groupBy", Value: groupBy}, {Name: "boundaries", Value: boundaries}}
+	if def != nil {
+		spec = append(spec, bson.DocElem{Name: "default", Value: def})
+	}
+	if len(accumulators) > 0 {
+		output := bson.D{}
+		for _, acc := range accumulators {
+			output = append(output, bson.DocElem{Name: acc.field, Value: acc.expr})
+		}
+		spec = append(spec, bson.DocElem{Name: "output", Value: output})
+	}
+	return p.stage("$bucket", spec)
+}
+
+// Out writes the output documents to collection, replacing its content.
+// It must be the last stage.
+func (p Pipeline) Out(collection string) Pipeline {
+	if collection == "" {
+		return p.fail("$out needs a collection")
+	}
+	return p.stage("$out", collection)
+}
+
+// boundaryLess reports whether the $bucket boundary a sorts before b, and
+// whether the two can be compared: numbers, strings, dates and ObjectIds
+// are compared with values of their own kind.
+func boundaryLess(a, b interface{}) (less, ok bool) {
+	if x, ok := boundaryNumber(a); ok {
+		y, ok := boundaryNumber(b)
+		return ok && x < y, ok
+	}
+	switch x := a.(type) {
+	case string:
+		y, ok := b.(string)
+		return ok && x < y, ok
+	case bson.ObjectId:
+		y, ok := b.(bson.ObjectId)
+		return ok && x < y, ok
+	case time.Time:
+		y, ok := b.(time.Time)
+		return ok && x.Before(y), ok
+	}
+	return false, false
+}
+
+func boundaryNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// MergeSpec configures Pipeline.Merge. Empty fields keep the defaults of
+// the server.
+type MergeSpec struct {
+	Into           string   // Collection receiving the documents.
+	On             []string // Fields identifying documents, _id by default.
+	WhenMatched    string   // "replace", "keepExisting", "merge" (default), "fail".
+	WhenNotMatched string   // "insert" (default), "discard" or "fail".
+}
+
+// Merge merges the output documents into a collection, and must be the last
+// stage. It needs MongoDB 4.2.
+func (p Pipeline) Merge(spec MergeSpec) Pipeline {
+	if spec.Into == "" {
+		return p.fail("$merge needs a collection")
+	}
+	merge := bson.D{{Name: "into", Value: spec.Into}}
+	if len(spec.On) > 0 {
+		merge = append(merge, bson.DocElem{Name: "on", Value: spec.On})
+	}
+	if spec.WhenMatched != "" {
+		merge = append(merge, bson.DocElem{Name: "whenMatched", Value: spec.WhenMatched})
+	}
+	if spec.WhenNotMatched != "" {
+		merge = append(merge, bson.DocElem{Name: "whenNotMatched", Value: spec.WhenNotMatched})
+	}
+	return p.stage("$merge", merge)
+}
+
+// Stage adds a stage the builder has no method for, such as
+// Stage("$sample", bson.M{"size": 10}).
+func (p Pipeline) Stage(op string, spec interface{}) Pipeline {
+	if !strings.HasPrefix(op, "$") {
+		return p.fail("stage %q must start with $", op)
+	}
+	return p.stage(op, spec)
+}
+
+func (p Pipeline) stage(op string, spec interface{}) Pipeline {
+	if p.err != nil {
+		return p
+	}
+	if n := len(p.stages); n > 0 {
+		for last := range p.stages[n-1] {
+			if last == "$out" || last == "$merge" {
+				return p.fail("%s must be the last stage, found %s after it", last, op)
+			}
+		}
+	}
+	p.stages = append(p.stages[:len(p.stages):len(p.stages)], bson.M{op: spec})
+	return p
+}
+
+func (p Pipeline) fail(format string, args ...interface{}) Pipeline {
+	if p.err == nil {
+		p.err = fmt.Errorf("mdb: pipeline stage %d: "+format, append([]interface{}{len(p.stages) + 1}, args...)...)
+	}
+	return p
+}
+
+// Accumulator is a field computed by the $group or $bucket stages, built
+// with As.
+type Accumulator struct {
+	field string
+	expr  bson.D
+}
+
+// AccumulatorField names the field of an Accumulator, whose methods choose
+// the accumulator operator.
+type AccumulatorField string
+
+// As starts an accumulator computing field, as in As("total").Sum("$amount").
+func As(field string) AccumulatorField {
+	return AccumulatorField(field)
+}
+
+func (f AccumulatorField) acc(op string, expr interface{}) Accumulator {
+	return Accumulator{field: string(f), expr: bson.D{{Name: op, Value: expr}}}
+}
+
+// Sum totals the values of expr.
+func (f AccumulatorField) Sum(expr interface{}) Accumulator { return f.acc("$sum", expr) }
+
+// Count counts the documents.
+func (f AccumulatorField) Count() Accumulator { return f.acc("$sum", 1) }
+
+// Avg averages the values of expr.
+func (f AccumulatorField) Avg(expr interface{}) Accumulator { return f.acc("$avg", expr) }
+
+// Min keeps the lowest value of expr.
+func (f AccumulatorField) Min(expr interface{}) Accumulator { return f.acc("$min", expr) }
+
+// Max keeps the greatest value of expr.
+func (f AccumulatorField) Max(expr interface{}) Accumulator { return f.acc("$max", expr) }
+
+// First keeps the value of expr for the first document.
+func (f AccumulatorField) First(expr interface{}) Accumulator { return f.acc("$first", expr) }
+
+// Last keeps the value of expr for the last document.
+func (f AccumulatorField) Last(expr interface{}) Accumulator { return f.acc("$last", expr) }
+
+// Push collects the values of expr in an array.
+func (f AccumulatorField) Push(expr interface{}) Accumulator { return f.acc("$push", expr) }
+
+// AddToSet collects the distinct values of expr in an array.
+func (f AccumulatorField) AddToSet(expr interface{}) Accumulator { return f.acc("$addToSet", expr) }
+
+// StdDevPop computes the population standard deviation of expr.
+func (f AccumulatorField) StdDevPop(expr interface{}) Accumulator { return f.acc("$stdDevPop", expr) }
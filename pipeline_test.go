@@ -0,0 +1,128 @@
+package mdb
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+func TestPipelineStages(t *testing.T) {
+	p := NewPipeline().
+		Match(Where("status").Eq("paid")).
+		Group("$customer", As("total").Sum("$amount"), As("orders").Count()).
+		Sort("-total").
+		Limit(10)
+	want := `[{"$match":{"status":"paid"}},{"$group":{"_id":"$customer","total":{"$sum":"$amount"},"orders":{"$sum":1}}},{"$sort":{"total":-1}},{"$limit":10}]`
+	if got := p.String(); got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+	base := NewPipeline().Match(nil)
+	base.Limit(1)
+	if n := len(base.Stages()); n != 1 {
+		t.Fatalf("pipeline modified by derived pipeline: %d stages", n)
+	}
+
+	facet := NewPipeline().Facet(map[string]Pipeline{
+		"total": NewPipeline().Count("n"),
+		"top":   NewPipeline().Sort("-n").Limit(1),
+	})
+	want = `[{"$facet":{"top":[{"$sort":{"n":-1}},{"$limit":1}],"total":[{"$count":"n"}]}}]`
+	if got := facet.String(); got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+
+	invalid := []Pipeline{
+		NewPipeline().Out("archive").Match(nil),
+		NewPipeline().Merge(MergeSpec{Into: "totals"}).Limit(1),
+		NewPipeline().Facet(map[string]Pipeline{"copy": NewPipeline().Out("x")}),
+		NewPipeline().Limit(0),
+		NewPipeline().Unwind("items", false),
+		NewPipeline().Group(nil, As("_id").Count()),
+		NewPipeline().Bucket("$n", []interface{}{0}, nil),
+		NewPipeline().Bucket("$n", []interface{}{0, 100, 10}, nil),
+		NewPipeline().Bucket("$n", []interface{}{0, 0.0}, nil),
+		NewPipeline().Bucket("$n", []interface{}{0, "a"}, nil),
+		NewPipeline().Out(""),
+		NewPipeline().Stage("sample", nil),
+	}
+	for i, p := range invalid {
+		if p.Err() == nil {
+			t.Errorf("pipeline %d: invalid pipeline accepted: %s", i, p)
+		} else if !strings.HasPrefix(p.Err().Error(), "mdb: pipeline stage ") {
+			t.Errorf("pipeline %d: error %q", i, p.Err())
+		}
+	}
+	if err := NewPipeline().Bucket("$n", []interface{}{0, 2.5, int64(10)}, "other").Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewPipeline().Match(nil).Out("archive").Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPipelineAggregate(t *testing.T) {
+	_, db := dialTest(t)
+	orders := db.C("orders")
+	if err := orders.Insert(
+		bson.M{"_id": 1, "customer": "a", "amount": 10, "status": "paid", "items": []string{"x", "y"}},
+		bson.M{"_id": 2, "customer": "b", "amount": 5, "status": "paid", "items": []string{"x"}},
+		bson.M{"_id": 3, "customer": "a", "amount": 7, "status": "paid"},
+		bson.M{"_id": 4, "customer": "b", "amount": 50, "status": "new"},
+	); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.C("customers").Insert(bson.M{"_id": "a", "name": "Ale"}, bson.M{"_id": "b", "name": "Bob"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var totals []struct {
+		Id     string `bson:"_id"`
+		Total  int
+		Orders int
+	}
+	p := NewPipeline().
+		Match(Where("status").Eq("paid")).
+		Group("$customer", As("total").Sum("$amount"), As("orders").Count()).
+		Sort("-total")
+	if err := orders.Pipe(p).All(&totals); err != nil {
+		t.Fatal(err)
+	}
+	if len(totals) != 2 || totals[0].Id != "a" || totals[0].Total != 17 || totals[0].Orders != 2 || totals[1].Total != 5 {
+		t.Fatalf("totals: %+v", totals)
+	}
+
+	var items []struct {
+		Items    string
+		Customer []struct{ Name string }
+	}
+	p = NewPipeline().Unwind("$items", false).Lookup("customers", "customer", "_id", "customer").Sort("_id", "items")
+	if err := orders.Pipe(p).All(&items); err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 3 || items[1].Items != "y" || len(items[2].Customer) != 1 || items[2].Customer[0].Name != "Bob" {
+		t.Fatalf("items: %+v", items)
+	}
+
+	var facets struct {
+		Count   []struct{ N int }
+		Buckets []struct {
+			Id    int `bson:"_id"`
+			Count int
+		}
+	}
+	p = NewPipeline().Facet(map[string]Pipeline{
+		"count":   NewPipeline().Count("n"),
+		"buckets": NewPipeline().Bucket("$amount", []interface{}{0, 10, 100}, nil),
+	})
+	if err := orders.Pipe(p).One(&facets); err != nil {
+		t.Fatal(err)
+	}
+	if len(facets.Count) != 1 || facets.Count[0].N != 4 || len(facets.Buckets) != 2 || facets.Buckets[0].Count != 2 {
+		t.Fatalf("facets: %+v", facets)
+	}
+
+	if err := orders.Pipe(NewPipeline().Limit(-1)).All(&totals); err == nil {
+		t.Fatal("invalid pipeline sent")
+	}
+}
@@ -0,0 +1,288 @@
+package mdb
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// Populate fills in the documents of c referenced by results, as loaded by
+// Query.All, with a single query. The references are found at path, which
+// may go through nested documents and arrays of them with dots, and may
+// hold a single _id or an array of them. The referenced documents are
+// decoded into the field dest of the document holding the reference: a
+// value or pointer for a single reference, or a slice for an array of them,
+// in the order of the references. References to missing documents are left
+// out.
+//
+// Results may be a slice, or a pointer to a slice, of structs, pointers to
+// structs or maps, whose fields are named after their bson tags.
+//
+// For example, with posts referencing their author and commenters:
+//
+//     type Post struct {
+//         Author   bson.ObjectId   `bson:"author"`
+//         User     *User           `bson:"-"`
+//         Comments []struct {
+//             By   bson.ObjectId `bson:"by"`
+//             User User          `bson:"-"`
+//         } `bson:"comments"`
+//     }
+//
+//     err := db.C("posts").Find(nil).All(&posts)
+//     err = db.C("users").Populate(posts, "author", "User")
+//     err = db.C("users").Populate(posts, "comments.by", "User")
+//
+// Struct fields are matched by their bson name, or by their Go name when
+// skipped by bson, as above.
+func (c *Collection) Populate(results interface{}, path, dest string) error {
+	var refs []populateRef
+	var ids []interface{}
+	seen := map[interface{}]bool{}
+	err := walkPath(reflect.ValueOf(results), strings.Split(path, "."), func(holder reflect.Value, ref interface{}) error {
+		r := populateRef{holder: holder}
+		if v := reflect.ValueOf(ref); ref != nil && (v.Kind() == reflect.Slice || v.Kind() == reflect.Array) && v.Type() != bytesType {
+			r.many = true
+			for i := 0; i < v.Len(); i++ {
+				r.ids = append(r.ids, v.Index(i).Interface())
+			}
+		} else if ref != nil {
+			r.ids = []interface{}{ref}
+		}
+		for _, id := range r.ids {
+			if oid, ok := id.(bson.ObjectId); ok && !oid.Valid() {
+				continue
+			}
+			if key := refKey(id); !seen[key] {
+				seen[key] = true
+				ids = append(ids, id)
+			}
+		}
+		refs = append(refs, r)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	var docs []bson.Raw
+	q := c.Find(bson.D{{Name: "_id", Value: bson.D{{Name: "$in", Value: ids}}}})
+	if projection := populateProjection(refs, dest); projection != nil {
+		q = q.Select(projection)
+	}
+	if err := q.All(&docs); err != nil {
+		return err
+	}
+	byID := make(map[interface{}]bson.Raw, len(docs))
+	for _, doc := range docs {
+		var id struct {
+			Id interface{} `bson:"_id"`
+		}
+		if err := doc.Unmarshal(&id); err != nil {
+			return err
+		}
+		byID[refKey(id.Id)] = doc
+	}
+	for _, r := range refs {
+		if err := r.fill(dest, byID); err != nil {
+			return fmt.Errorf("mdb: Populate %s: %v", dest, err)
+		}
+	}
+	return nil
+}
+
+// populateRef is a document of the results holding a reference.
+type populateRef struct {
+	holder reflect.Value
+	ids    []interface{}
+	many   bool
+}
+
+// fill decodes the referenced documents into the dest field of the holder.
+func (r populateRef) fill(dest string, byID map[interface{}]bson.Raw) error {
+	if r.holder.Kind() == reflect.Map {
+		var list []interface{}
+		for _, id := range r.ids {
+			if doc, ok := byID[refKey(id)]; ok {
+				m := bson.M{}
+				if err := doc.Unmarshal(&m); err != nil {
+					return err
+				}
+				list = append(list, m)
+			}
+		}
+		switch {
+		case r.many:
+			r.holder.SetMapIndex(reflect.ValueOf(dest), reflect.ValueOf(list))
+		case len(list) == 1:
+			r.holder.SetMapIndex(reflect.ValueOf(dest), reflect.ValueOf(list[0]))
+		}
+		return nil
+	}
+	field, ok := destField(r.holder, dest)
+	if !ok {
+		return fmt.Errorf("%s has no such field", r.holder.Type())
+	}
+	if !field.CanSet() {
+		return fmt.Errorf("results must be a slice or a pointer")
+	}
+	t := field.Type()
+	if r.many {
+		if t.Kind() != reflect.Slice {
+			return fmt.Errorf("field for an array of references must be a slice, not %s", t)
+		}
+		list := reflect.MakeSlice(t, 0, len(r.ids))
+		for _, id := range r.ids {
+			if doc, ok := byID[refKey(id)]; ok {
+				v := reflect.New(t.Elem())
+				if err := doc.Unmarshal(v.Interface()); err != nil {
+					return err
+				}
+				list = reflect.Append(list, v.Elem())
+			}
+		}
+		field.Set(list)
+		return nil
+	}
+	if len(r.ids) == 0 {
+		return nil
+	}
+	if doc, ok := byID[refKey(r.ids[0])]; ok {
+		v := reflect.New(t)
+		if err := doc.Unmarshal(v.Interface()); err != nil {
+			return err
+		}
+		field.Set(v.Elem())
+	}
+	return nil
+}
+
+// populateProjection returns the projection of the struct type populated
+// into dest, keeping _id which Populate needs, or nil to fetch everything.
+func populateProjection(refs []populateRef, dest string) bson.D {
+	if len(refs) == 0 || refs[0].holder.Kind() != reflect.Struct {
+		return nil
+	}
+	field, ok := destField(refs[0].holder, dest)
+	if !ok {
+		return nil
+	}
+	t := field.Type()
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	projection, err := ProjectionFor(reflect.Zero(t).Interface())
+	if err != nil || projection == nil {
+		return nil
+	}
+	out := bson.D{}
+	for _, e := range projection {
+		if e.Name != "_id" {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// walkPath calls visit with every document reachable from v through path,
+// and the value it holds at the last element of path.
+func walkPath(v reflect.Value, path []string, visit func(holder reflect.Value, ref interface{}) error) error {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := walkPath(v.Index(i), path, visit); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("mdb: Populate: unsupported map type %s", v.Type())
+		}
+		field := v.MapIndex(reflect.ValueOf(path[0]).Convert(v.Type().Key()))
+		if !field.IsValid() {
+			return nil
+		}
+		if len(path) == 1 {
+			return visit(v, field.Interface())
+		}
+		return walkPath(field, path[1:], visit)
+	case reflect.Struct:
+		field, ok := fieldByBSONName(v, path[0])
+		if !ok {
+			return fmt.Errorf("mdb: Populate: %s has no field %s", v.Type(), path[0])
+		}
+		if len(path) == 1 {
+			return visit(v, field.Interface())
+		}
+		return walkPath(field, path[1:], visit)
+	}
+	return nil
+}
+
+// fieldByBSONName returns the field of the struct v named name in
+// documents, looking into inline structs.
+func fieldByBSONName(v reflect.Value, name string) (reflect.Value, bool) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			continue
+		}
+		fieldName, inline, skip := bsonFieldName(f)
+		switch {
+		case skip:
+		case inline && f.Type.Kind() == reflect.Struct:
+			if field, ok := fieldByBSONName(v.Field(i), name); ok {
+				return field, true
+			}
+		case fieldName == name:
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// destField returns the field of the struct v receiving populated documents,
+// which is named dest in documents or, if skipped by bson, in Go.
+func destField(v reflect.Value, dest string) (reflect.Value, bool) {
+	if field, ok := fieldByBSONName(v, dest); ok {
+		return field, true
+	}
+	field := v.FieldByName(dest)
+	return field, field.IsValid()
+}
+
+// refKey returns a map key identifying id whatever the integer type it was
+// decoded as.
+func refKey(id interface{}) interface{} {
+	v := reflect.ValueOf(id)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(v.Uint())
+	case reflect.String:
+		if oid, ok := id.(bson.ObjectId); ok {
+			return oid
+		}
+		return v.String()
+	}
+	if v.IsValid() && v.Type().Comparable() {
+		return id
+	}
+	return fmt.Sprintf("%#v", id)
+}
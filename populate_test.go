@@ -0,0 +1,85 @@
+package mdb
+
+import (
+	"testing"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+type populatedUser struct {
+	Id   bson.ObjectId `bson:"_id"`
+	Name string        `bson:"name"`
+}
+
+type populatedPost struct {
+	Author   bson.ObjectId   `bson:"author"`
+	User     *populatedUser  `bson:"-"`
+	Likes    []bson.ObjectId `bson:"likes"`
+	Likers   []populatedUser `bson:"likers,omitempty"`
+	Comments []struct {
+		By   bson.ObjectId `bson:"by"`
+		User populatedUser `bson:"-"`
+	} `bson:"comments"`
+}
+
+func TestPopulate(t *testing.T) {
+	srv, db := dialTest(t)
+	users := db.C("users")
+	ale, bob, cla := bson.NewObjectId(), bson.NewObjectId(), bson.NewObjectId()
+	if err := users.Insert(
+		bson.M{"_id": ale, "name": "Ale", "phone": "+55"},
+		bson.M{"_id": bob, "name": "Bob"},
+	); err != nil {
+		t.Fatal(err)
+	}
+	posts := db.C("posts")
+	if err := posts.Insert(
+		bson.M{"author": ale, "likes": []bson.ObjectId{bob, cla, ale}, "comments": []bson.M{{"by": bob}, {"by": ale}}},
+		bson.M{"author": cla, "likes": []bson.ObjectId{}},
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	var result []populatedPost
+	if err := posts.Find(nil).Sort("_id").All(&result); err != nil {
+		t.Fatal(err)
+	}
+	before := srv.Received("find")
+	for _, ref := range [][2]string{{"author", "User"}, {"likes", "likers"}, {"comments.by", "User"}} {
+		if err := users.Populate(result, ref[0], ref[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := srv.Received("find") - before; n != 3 {
+		t.Fatalf("%d queries, want 3", n)
+	}
+	first := result[0]
+	if first.User == nil || first.User.Name != "Ale" || result[1].User != nil {
+		t.Fatalf("authors: %+v %+v", first.User, result[1].User)
+	}
+	if len(first.Likers) != 2 || first.Likers[0].Name != "Bob" || first.Likers[1].Name != "Ale" {
+		t.Fatalf("likers: %+v", first.Likers)
+	}
+	if first.Comments[0].User.Name != "Bob" || first.Comments[1].User.Name != "Ale" {
+		t.Fatalf("comments: %+v", first.Comments)
+	}
+
+	var docs []bson.M
+	if err := posts.Find(bson.M{"author": ale}).All(&docs); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.Populate(&docs, "comments.by", "user"); err != nil {
+		t.Fatal(err)
+	}
+	comment := docs[0]["comments"].([]interface{})[0].(bson.M)
+	if user, _ := comment["user"].(bson.M); user["name"] != "Bob" {
+		t.Fatalf("populated map: %v", comment)
+	}
+
+	if err := users.Populate(result, "missing", "User"); err == nil {
+		t.Fatal("unknown path accepted")
+	}
+	if err := users.Populate(result, "likes", "User"); err == nil {
+		t.Fatal("mismatched destination accepted")
+	}
+}
@@ -0,0 +1,131 @@
+package mdb
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// ProjectionFor derives from model, which must be a struct or a pointer to
+// one, a projection selecting the fields it decodes. Field names are taken
+// from the bson tags; fields of inline structs are selected as fields of the
+// document, and fields of nested structs, or of arrays of them, with dots:
+//
+//     type Person struct {
+//         Id      bson.ObjectId `bson:"_id"`
+//         Name    string        `bson:"name"`
+//         Address struct {
+//             City string `bson:"city"`
+//         } `bson:"address"`
+//     }
+//     // {_id: 1, name: 1, "address.city": 1}
+//
+// The _id field is excluded when model has none. Structs implementing
+// bson.Getter or bson.Setter are selected whole. A model holding an inline
+// map decodes every field, so its projection is nil.
+func ProjectionFor(model interface{}) (bson.D, error) {
+	t := reflect.TypeOf(model)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("mdb: ProjectionFor needs a struct, got %T", model)
+	}
+	if p, ok := projections.Load(t); ok {
+		return p.(bson.D), nil
+	}
+	var fields []string
+	var projection bson.D
+	if projectFields(t, "", &fields, map[reflect.Type]bool{}) {
+		projection = bson.D{}
+		if !containsString(fields, "_id") {
+			projection = append(projection, bson.DocElem{Name: "_id", Value: 0})
+		}
+		for _, field := range fields {
+			projection = append(projection, bson.DocElem{Name: field, Value: 1})
+		}
+	}
+	projections.Store(t, projection)
+	return projection, nil
+}
+
+// projections caches the projection of each struct type.
+var projections sync.Map
+
+var (
+	getterType     = reflect.TypeOf((*bson.Getter)(nil)).Elem()
+	setterType     = reflect.TypeOf((*bson.Setter)(nil)).Elem()
+	rawType        = reflect.TypeOf(bson.Raw{})
+	docElemType    = reflect.TypeOf(bson.DocElem{})
+	rawDocElemType = reflect.TypeOf(bson.RawDocElem{})
+)
+
+// projectFields appends to fields the paths of the fields of t. It returns
+// false if t holds an inline map, whose fields can't be known.
+func projectFields(t reflect.Type, prefix string, fields *[]string, seen map[reflect.Type]bool) bool {
+	seen[t] = true
+	defer delete(seen, t)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			continue
+		}
+		name, inline, skip := bsonFieldName(f)
+		if skip {
+			continue
+		}
+		if inline {
+			if f.Type.Kind() == reflect.Map {
+				return false
+			}
+			if !projectFields(f.Type, prefix, fields, seen) {
+				return false
+			}
+			continue
+		}
+		ft := f.Type
+		for ft.Kind() == reflect.Ptr || ft.Kind() == reflect.Slice && ft != bytesType || ft.Kind() == reflect.Array {
+			ft = ft.Elem()
+		}
+		n := len(*fields)
+		if wholeField(ft) || seen[ft] || !projectFields(ft, prefix+name+".", fields, seen) {
+			*fields = (*fields)[:n]
+		}
+		if len(*fields) == n {
+			*fields = append(*fields, prefix+name)
+		}
+	}
+	return true
+}
+
+// wholeField reports whether fields of type t must be selected whole rather
+// than by their own fields.
+func wholeField(t reflect.Type) bool {
+	if t.Kind() != reflect.Struct {
+		return true
+	}
+	switch t {
+	case timeType, rawType, docElemType, rawDocElemType:
+		return true
+	}
+	return t.Implements(getterType) || t.Implements(setterType) ||
+		reflect.PtrTo(t).Implements(getterType) || reflect.PtrTo(t).Implements(setterType)
+}
+
+// SelectFor selects the fields decoded by model, as derived by
+// ProjectionFor. The query is left unchanged if model is not a struct.
+//
+// For example:
+//
+//     var people []Person
+//     err := collection.Find(nil).SelectFor(&Person{}).All(&people)
+//
+func (q *Query) SelectFor(model interface{}) *Query {
+	projection, err := ProjectionFor(model)
+	if err != nil || projection == nil {
+		return q
+	}
+	return q.Select(projection)
+}
@@ -0,0 +1,97 @@
+package mdb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+type projectedBase struct {
+	Created time.Time `bson:"created"`
+}
+
+type projectedItem struct {
+	Sku string `bson:"sku"`
+	Qty int    `bson:"qty"`
+}
+
+type projectedOrder struct {
+	Id       int             `bson:"_id"`
+	Base     projectedBase   `bson:",inline"`
+	Customer *struct {
+		Name string `bson:"name"`
+	} `bson:"customer"`
+	Items   []projectedItem `bson:"items"`
+	Tags    []string        `bson:"tags,omitempty"`
+	Raw     bson.Raw        `bson:"raw"`
+	Skipped string          `bson:"-"`
+	Empty   struct{}        `bson:"empty"`
+	private string
+}
+
+func TestProjectionFor(t *testing.T) {
+	p, err := ProjectionFor(&projectedOrder{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"_id", "created", "customer.name", "items.sku", "items.qty", "tags", "raw", "empty"}
+	if len(p) != len(want) {
+		t.Fatalf("projection %v, want fields %v", p, want)
+	}
+	for i, name := range want {
+		if p[i].Name != name || p[i].Value != 1 {
+			t.Errorf("field %d: %v, want %s", i, p[i], name)
+		}
+	}
+
+	p, err = ProjectionFor(projectedItem{})
+	if err != nil || len(p) != 3 || p[0].Name != "_id" || p[0].Value != 0 {
+		t.Fatalf("projection without _id: %v %v", p, err)
+	}
+	p, err = ProjectionFor(struct {
+		Name  string `bson:"name"`
+		Extra bson.M `bson:",inline"`
+	}{})
+	if err != nil || p != nil {
+		t.Fatalf("projection with inline map: %v %v", p, err)
+	}
+	if _, err := ProjectionFor(bson.M{}); err == nil {
+		t.Fatal("projection of a map")
+	}
+}
+
+func TestSelectFor(t *testing.T) {
+	_, db := dialTest(t)
+	c := db.C("orders")
+	if err := c.Insert(bson.M{
+		"_id":      1,
+		"created":  time.Date(2018, 6, 11, 0, 0, 0, 0, time.UTC),
+		"customer": bson.M{"name": "Ale", "phone": "+55"},
+		"items":    []bson.M{{"sku": "a", "qty": 1, "price": 5}},
+		"notes":    "wide field",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	var doc bson.M
+	if err := c.Find(nil).SelectFor(&projectedOrder{}).One(&doc); err != nil {
+		t.Fatal(err)
+	}
+	customer, _ := doc["customer"].(bson.M)
+	items, _ := doc["items"].([]interface{})
+	if doc["notes"] != nil || customer["phone"] != nil || customer["name"] != "Ale" || len(items) != 1 {
+		t.Fatalf("selected %v", doc)
+	}
+	if item, _ := items[0].(bson.M); item["price"] != nil || item["qty"] != 1 {
+		t.Fatalf("selected item %v", item)
+	}
+
+	order, err := Typed[projectedItem](c).FindOne(nil)
+	if err != nil || order.Sku != "" {
+		t.Fatalf("typed find: %+v %v", order, err)
+	}
+	orders, err := Typed[projectedOrder](c).FindAll(nil)
+	if err != nil || len(orders) != 1 || orders[0].Customer.Name != "Ale" || orders[0].Items[0].Qty != 1 {
+		t.Fatalf("typed find all: %+v %v", orders, err)
+	}
+}
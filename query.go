@@ -8,8 +8,17 @@ import (
 
 // Query keeps info on the query.
 type Query struct {
-	q  *mgo.Query
-	db *Database
+	q   *mgo.Query
+	db  *Database
+	col *Collection
+
+	filter   interface{}
+	sort     []string
+	selector interface{}
+	maxCount int
+	skip     int
+	limit    int
+	batch    int
 }
 
 // Batch sets the batch size used when fetching documents from the database.
@@ -20,6 +29,7 @@ type Query struct {
 // first batch, and 4MB on remaining ones.
 func (q *Query) Batch(n int) *Query {
 	q.q = q.q.Batch(n)
+	q.batch = n
 	return q
 }
 
@@ -43,6 +53,7 @@ func (q *Query) Prefetch(p float64) *Query {
 // this only makes sense with capped collections where documents are naturally
 // ordered by insertion time, or with sorted results.
 func (q *Query) Skip(n int) *Query {
+	q.skip = n
 	q.q = q.q.Skip(n)
 	return q
 }
@@ -52,6 +63,7 @@ func (q *Query) Skip(n int) *Query {
 // returned by Next, the following call will return ErrNotFound.
 func (q *Query) Limit(n int) *Query {
 	q.q = q.q.Limit(n)
+	q.limit = n
 	return q
 }
 
@@ -66,6 +78,7 @@ func (q *Query) Limit(n int) *Query {
 //
 func (q *Query) Select(selector interface{}) *Query {
 	q.q = q.q.Select(selector)
+	q.selector = selector
 	return q
 }
 
@@ -86,6 +99,7 @@ func (q *Query) Select(selector interface{}) *Query {
 //
 func (q *Query) Sort(fields ...string) *Query {
 	q.q = q.q.Sort(fields...)
+	q.sort = fields
 	return q
 }
 
@@ -109,7 +123,9 @@ func (q *Query) Sort(fields ...string) *Query {
 //
 func (q *Query) Explain(result interface{}) (err error) {
 	for i := 0; i < q.db.MaxConnectRetries; i++ {
-		err = q.q.Explain(result)
+		if err = q.db.fault(q.col.Name, OpExplain); err == nil {
+			err = q.q.Explain(result)
+		}
 		if err == nil {
 			return
 		}
@@ -255,9 +271,11 @@ func (q *Query) LogReplay() *Query {
 //
 func (q *Query) One(result interface{}) (err error) {
 	for i := 0; i < q.db.MaxConnectRetries; i++ {
-		err = q.q.One(result)
+		if err = q.db.fault(q.col.Name, OpOne); err == nil {
+			err = q.q.One(result)
+		}
 		if err == nil {
-			return
+			return afterFind(result)
 		}
 		if !isNetworkError(err) {
 			continue
@@ -269,7 +287,16 @@ func (q *Query) One(result interface{}) (err error) {
 
 // Count returns the total number of documents in the result set.
 func (q *Query) Count() (n int, err error) {
-	return q.q.Count()
+	for i := 0; i < q.db.MaxConnectRetries; i++ {
+		if err = q.db.fault(q.col.Name, OpCount); err == nil {
+			n, err = q.q.Count()
+		}
+		if !isNetworkError(err) {
+			return
+		}
+		q.db.refresh()
+	}
+	return n, err
 }
 
 // Iter executes the query and returns an iterator capable of going over all
@@ -278,8 +305,9 @@ func (q *Query) Count() (n int, err error) {
 // configurable number of documents is iterated over (see the Prefetch method).
 func (q *Query) Iter() *Iter {
 	return &Iter{
-		i:  q.q.Iter(),
-		db: q.db,
+		i:   q.q.Iter(),
+		db:  q.db,
+		col: q.col,
 	}
 
 }
@@ -390,9 +418,26 @@ func (q *Query) MapReduce(job *mgo.MapReduce, result interface{}) (info *mgo.Map
 //     http://www.mongodb.org/display/DOCS/Atomic+Operations
 //
 func (q *Query) Apply(change mgo.Change, result interface{}) (info *mgo.ChangeInfo, err error) {
+	change = q.col.softDeleteChange(change)
+	var arrayFilters []interface{}
+	if change.Update, arrayFilters, err = splitUpdate(change.Update); err != nil {
+		return nil, err
+	}
+	if change, err = q.col.timestamps.stampChange(change); err != nil {
+		return nil, err
+	}
 	for i := 0; i < q.db.MaxConnectRetries; i++ {
-		info, err = q.q.Apply(change, result)
+		if err = q.db.fault(q.col.Name, OpApply); err == nil {
+			if arrayFilters != nil {
+				info, err = q.runFindAndModify(change, arrayFilters, result)
+			} else {
+				info, err = q.q.Apply(change, result)
+			}
+		}
 		if err == nil {
+			if result != nil {
+				err = afterFind(result)
+			}
 			return
 		}
 		if !isNetworkError(err) {
@@ -406,9 +451,11 @@ func (q *Query) Apply(change mgo.Change, result interface{}) (info *mgo.ChangeIn
 // All works like Iter.All.
 func (q *Query) All(result interface{}) (err error) {
 	for i := 0; i < q.db.MaxConnectRetries; i++ {
-		err = q.q.All(result)
+		if err = q.db.fault(q.col.Name, OpAll); err == nil {
+			err = q.q.All(result)
+		}
 		if err == nil {
-			return
+			return afterFind(result)
 		}
 		if !isNetworkError(err) {
 			continue
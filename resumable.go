@@ -0,0 +1,146 @@
+package mdb
+
+import (
+	"reflect"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+// resumeState is what a resumable Iter needs to query the documents
+// following the last one it yielded.
+type resumeState struct {
+	query *Query
+	sort  bson.D
+	last  []interface{} // sort key of the last document yielded
+	n     int           // documents yielded
+}
+
+// ResumableIter works like Iter, but the iterator survives a broken
+// connection: when Next fails with a network error, the session is
+// refreshed and the query is issued again for the documents following the
+// last one yielded, up to MaxConnectRetries times in a row.
+//
+// To know where to resume, the results are sorted by the fields given to
+// Sort followed by _id, or by _id alone, and the fields selected with Select
+// must include the sort fields. The query is issued again with its query
+// document, selector, batch size and the remainder of its limit, and with
+// its skip if no document was yielded yet.
+//
+// For example:
+//
+//     iter := collection.Find(nil).Batch(1000).ResumableIter()
+//     for iter.Next(&result) {
+//         process(result)
+//     }
+//     if err := iter.Close(); err != nil {
+//         return err
+//     }
+//
+func (q *Query) ResumableIter() *Iter {
+	sort := sortDoc(q.sort)
+	if !hasField(sort, "_id") {
+		sort = append(sort, bson.DocElem{Name: "_id", Value: 1})
+	}
+	q.Sort(keysetSort(sort, false)...)
+	return &Iter{
+		i:      q.q.Iter(),
+		db:     q.db,
+		col:    q.col,
+		resume: &resumeState{query: q, sort: sort},
+	}
+}
+
+// resumableNext implements Next for resumable iterators.
+func (iter *Iter) resumableNext(result interface{}) bool {
+	r := iter.resume
+	for i := 0; iter.err == nil; i++ {
+		if r.query.limit > 0 && r.n >= r.query.limit {
+			// Nothing is left to query, even after a failure.
+			return false
+		}
+		var raw bson.Raw
+		err := iter.db.fault(iter.col.Name, OpIterNext)
+		if err == nil {
+			if iter.i.Next(&raw) {
+				return iter.yield(raw, result)
+			}
+			err = iter.i.Err()
+		}
+		if !isNetworkError(err) || i+1 >= iter.db.MaxConnectRetries {
+			if err != nil && iter.i.Err() == nil {
+				iter.err = err
+			}
+			return false
+		}
+		iter.db.refresh()
+		iter.i.Close()
+		iter.i = r.reissue()
+	}
+	return false
+}
+
+// yield decodes raw into result, recording its sort key.
+func (iter *Iter) yield(raw bson.Raw, result interface{}) bool {
+	r := iter.resume
+	key, err := keysetKey(r.sort, raw)
+	if err == nil {
+		err = raw.Unmarshal(result)
+	}
+	if err == nil {
+		err = afterFind(result)
+	}
+	if err != nil {
+		iter.err = err
+		return false
+	}
+	r.last = key
+	r.n++
+	return true
+}
+
+// reissue queries the documents following the last one yielded.
+func (r *resumeState) reissue() *mgo.Iter {
+	q := r.query
+	filter := q.filter
+	if r.last != nil {
+		after := keysetFilter(r.sort, r.last, false)
+		if filter == nil {
+			filter = after
+		} else {
+			filter = bson.D{{Name: "$and", Value: []interface{}{filter, after}}}
+		}
+	}
+	mq := q.col.col.Find(filter).Sort(keysetSort(r.sort, false)...)
+	if q.selector != nil {
+		mq.Select(q.selector)
+	}
+	if q.batch > 0 {
+		mq.Batch(q.batch)
+	}
+	if r.last == nil && q.skip > 0 {
+		mq.Skip(q.skip)
+	}
+	if q.limit > 0 {
+		mq.Limit(q.limit - r.n)
+	}
+	return mq.Iter()
+}
+
+// resumableAll implements All for resumable iterators.
+func (iter *Iter) resumableAll(result interface{}) error {
+	resultv := reflect.ValueOf(result)
+	if resultv.Kind() != reflect.Ptr || resultv.Elem().Kind() != reflect.Slice {
+		panic("result argument must be a slice address")
+	}
+	slicev := resultv.Elem().Slice(0, 0)
+	for {
+		elemp := reflect.New(slicev.Type().Elem())
+		if !iter.Next(elemp.Interface()) {
+			break
+		}
+		slicev = reflect.Append(slicev, elemp.Elem())
+	}
+	resultv.Elem().Set(slicev)
+	return iter.Close()
+}
@@ -0,0 +1,101 @@
+package mdb
+
+import (
+	"testing"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+func TestResumableIter(t *testing.T) {
+	srv, db := dialTest(t)
+	c := db.C("people")
+	for i := 0; i < 9; i++ {
+		if err := c.Insert(bson.M{"_id": i, "age": i % 3}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	type person struct {
+		Id  int `bson:"_id"`
+		Age int
+	}
+
+	srv.DropNext("getMore", 1)
+	iter := c.Find(bson.M{"_id": bson.M{"$gte": 1}}).Batch(2).ResumableIter()
+	var ids []int
+	var p person
+	for iter.Next(&p) {
+		ids = append(ids, p.Id)
+	}
+	if err := iter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := srv.Received("find"); n != 2 {
+		t.Fatalf("find received %d times, want 2", n)
+	}
+	if len(ids) != 8 || ids[0] != 1 || ids[7] != 8 {
+		t.Fatalf("iterated %v", ids)
+	}
+	for i := 1; i < len(ids); i++ {
+		if ids[i] <= ids[i-1] {
+			t.Fatalf("iterated %v", ids)
+		}
+	}
+
+	faults := NewFaultInjector()
+	db.SetFaultInjector(faults)
+	iter = c.Find(nil).Sort("-age").Limit(5).ResumableIter()
+	var people []person
+	for iter.Next(&p) {
+		people = append(people, p)
+		if len(people) == 2 {
+			faults.FailNext("people", OpIterNext, 1, ErrFaultReset)
+		}
+	}
+	if err := iter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want := []int{2, 5, 8, 1, 4}
+	if len(people) != len(want) {
+		t.Fatalf("iterated %v", people)
+	}
+	for i, id := range want {
+		if people[i].Id != id {
+			t.Fatalf("iterated %v, want ids %v", people, want)
+		}
+	}
+
+	iter = c.Find(nil).Limit(3).ResumableIter()
+	people = nil
+	for iter.Next(&p) {
+		people = append(people, p)
+		if len(people) == 3 {
+			faults.FailNext("people", OpIterNext, 1, ErrFaultReset)
+		}
+	}
+	if err := iter.Close(); err != nil || len(people) != 3 {
+		t.Fatalf("failure after the limit: iterated %v, %v", people, err)
+	}
+	faults.Reset()
+
+	faults.FailNext("people", OpIterNext, 1, ErrFaultEOF)
+	var all []person
+	if err := c.Find(bson.M{"age": 0}).ResumableIter().All(&all); err != nil || len(all) != 3 {
+		t.Fatalf("all: %v %v", all, err)
+	}
+
+	faults.FailNext("people", OpIterNext, 1, ErrFaultEOF)
+	iter = c.Find(nil).Skip(6).ResumableIter()
+	ids = nil
+	for iter.Next(&p) {
+		ids = append(ids, p.Id)
+	}
+	if err := iter.Close(); err != nil || len(ids) != 3 || ids[0] != 6 {
+		t.Fatalf("skip after a failure before the first document: %v %v", ids, err)
+	}
+
+	faults.FailNext("people", OpIterNext, db.MaxConnectRetries, ErrFaultEOF)
+	iter = c.Find(nil).ResumableIter()
+	if iter.Next(&p) || iter.Err() != ErrFaultEOF {
+		t.Fatalf("iterator survived %d failures: %v", db.MaxConnectRetries, iter.Err())
+	}
+}
@@ -0,0 +1,233 @@
+package mdb
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// Numbers of ranges a parallel scan makes per worker, and of _id samples
+// taken per range when splitVector is not available.
+const (
+	scanRangesPerWorker = 4
+	scanSamplesPerRange = 10
+)
+
+// ScanOptions configures Collection.ParallelScan.
+type ScanOptions struct {
+	// Workers is the number of cursors used at once, 1 at least.
+	Workers int
+	// Progress, if not nil, is called each time a range is fully scanned.
+	// Calls are serialized.
+	Progress func(ScanProgress)
+}
+
+// ScanProgress reports the progress of Collection.ParallelScan.
+type ScanProgress struct {
+	Ranges int   // Ranges the _id space was split into.
+	Done   int   // Ranges fully scanned.
+	Docs   int64 // Documents passed to the scan function so far.
+}
+
+// ParallelScan calls fn with every document matching filter, using up to
+// opts.Workers cursors at once. The _id space is split into ranges with the
+// splitVector command or, where it is not available such as on mongos,
+// by sampling _id values with $sample; each range is then scanned in _id
+// order by a resumable iterator (see Query.ResumableIter), so a broken
+// connection only makes a range resume where it stopped.
+//
+// As comparisons only match values of the same type, ranges are made for
+// each type of _id found while splitting, and a last range holds the
+// documents whose _id is of any other type.
+//
+// fn is called concurrently from the workers. Only network errors are
+// retried: the scan stops at the first other error of a range, or error
+// returned by fn, which ParallelScan returns.
+//
+// For example:
+//
+//     var total int64
+//     err := c.ParallelScan(bson.M{"status": "paid"}, mdb.ScanOptions{Workers: 8}, func(doc bson.Raw) error {
+//         var order Order
+//         if err := doc.Unmarshal(&order); err != nil {
+//             return err
+//         }
+//         atomic.AddInt64(&total, order.Amount)
+//         return nil
+//     })
+//
+func (c *Collection) ParallelScan(filter interface{}, opts ScanOptions, fn func(doc bson.Raw) error) error {
+	workers := opts.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	bounds, err := c.scanBounds(workers * scanRangesPerWorker)
+	if err != nil {
+		return err
+	}
+	ranges := scanRanges(bounds)
+	progress := ScanProgress{Ranges: len(ranges)}
+	var mu sync.Mutex
+	var firstErr error
+	var docs int64
+	var stopped int32
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				done, err := c.scanRange(filter, ranges[i], i, &docs, &stopped, fn)
+				mu.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = err
+					atomic.StoreInt32(&stopped, 1)
+				}
+				if done {
+					progress.Done++
+					if opts.Progress != nil {
+						progress.Docs = atomic.LoadInt64(&docs)
+						opts.Progress(progress)
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := 0; i < progress.Ranges && atomic.LoadInt32(&stopped) == 0; i++ {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return firstErr
+}
+
+// scanRange scans the documents matching filter whose _id matches cond, the
+// condition of the i-th range, counting them into docs, until stopped is
+// set. It reports whether the range was scanned to its end.
+func (c *Collection) scanRange(filter interface{}, cond bson.D, i int, docs *int64, stopped *int32, fn func(bson.Raw) error) (done bool, err error) {
+	query := filter
+	if cond != nil {
+		query = bson.D{{Name: "_id", Value: cond}}
+		if filter != nil {
+			query = bson.D{{Name: "$and", Value: []interface{}{filter, query}}}
+		}
+	}
+	iter := c.Find(query).ResumableIter()
+	var doc bson.Raw
+	for atomic.LoadInt32(stopped) == 0 {
+		if !iter.Next(&doc) {
+			if err := iter.Close(); err != nil {
+				return false, fmt.Errorf("mdb: scan of %s range %d: %v", c.Name, i, err)
+			}
+			return true, nil
+		}
+		if err := fn(doc); err != nil {
+			iter.Close()
+			return false, err
+		}
+		atomic.AddInt64(docs, 1)
+	}
+	iter.Close()
+	return false, nil
+}
+
+// scanRanges returns the _id conditions of the ranges split by bounds, which
+// are sorted. Comparisons only match values of the type of the bound, so
+// the bounds are grouped by type, each group covering every _id of its
+// type, and a last range covers the _id of other types. A nil condition
+// covers every document.
+func scanRanges(bounds []interface{}) []bson.D {
+	var ranges []bson.D
+	var types []interface{}
+	for i := 0; i < len(bounds); {
+		t := scanType(bounds[i])
+		j := i + 1
+		for j < len(bounds) && scanType(bounds[j]) == t {
+			j++
+		}
+		if t != "" {
+			types = append(types, t)
+			ranges = append(ranges, bson.D{{Name: "$type", Value: t}, {Name: "$lt", Value: bounds[i]}})
+			for k := i + 1; k < j; k++ {
+				ranges = append(ranges, bson.D{{Name: "$type", Value: t}, {Name: "$gte", Value: bounds[k-1]}, {Name: "$lt", Value: bounds[k]}})
+			}
+			ranges = append(ranges, bson.D{{Name: "$type", Value: t}, {Name: "$gte", Value: bounds[j-1]}})
+		}
+		i = j
+	}
+	if len(types) == 0 {
+		return []bson.D{nil}
+	}
+	return append(ranges, bson.D{{Name: "$not", Value: bson.D{{Name: "$type", Value: types}}}})
+}
+
+// scanType returns the $type alias of an _id bound, or "" for types which
+// can't be split into ranges.
+func scanType(v interface{}) string {
+	switch v.(type) {
+	case int, int32, int64, float64:
+		return "number"
+	case string:
+		return "string"
+	case bson.ObjectId:
+		return "objectId"
+	case time.Time:
+		return "date"
+	case bool:
+		return "bool"
+	case bson.M, bson.D:
+		return "object"
+	case []byte, bson.Binary:
+		return "binData"
+	}
+	return ""
+}
+
+// scanBounds returns the sorted _id values splitting c into about n
+// ranges.
+func (c *Collection) scanBounds(n int) ([]interface{}, error) {
+	count, err := c.Count()
+	if err != nil || count == 0 || n < 2 {
+		return nil, err
+	}
+	var split struct {
+		SplitKeys []struct {
+			Id interface{} `bson:"_id"`
+		} `bson:"splitKeys"`
+	}
+	err = c.Database.Run(bson.D{
+		{Name: "splitVector", Value: c.Database.Name + "." + c.Name},
+		{Name: "keyPattern", Value: bson.D{{Name: "_id", Value: 1}}},
+		{Name: "maxChunkSize", Value: 1024},
+		{Name: "maxChunkObjects", Value: (count + n - 1) / n},
+	}, &split)
+	var bounds []interface{}
+	if err == nil {
+		for _, key := range split.SplitKeys {
+			bounds = append(bounds, key.Id)
+		}
+		return bounds, nil
+	}
+	var samples []struct {
+		Id interface{} `bson:"_id"`
+	}
+	p := NewPipeline().
+		Stage("$sample", bson.D{{Name: "size", Value: n * scanSamplesPerRange}}).
+		Project(bson.D{{Name: "_id", Value: 1}}).
+		Sort("_id")
+	if err := c.Pipe(p).All(&samples); err != nil {
+		return nil, fmt.Errorf("mdb: can't split %s for a parallel scan: %v", c.Name, err)
+	}
+	for i := scanSamplesPerRange; i < len(samples); i += scanSamplesPerRange {
+		if len(bounds) == 0 || !reflect.DeepEqual(samples[i].Id, bounds[len(bounds)-1]) {
+			bounds = append(bounds, samples[i].Id)
+		}
+	}
+	return bounds, nil
+}
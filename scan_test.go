@@ -0,0 +1,141 @@
+package mdb
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+func TestParallelScan(t *testing.T) {
+	srv, db := dialTest(t)
+	for i := 0; i < 100; i++ {
+		if err := db.C("events").Insert(bson.M{"_id": i, "odd": i%2 == 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var mu sync.Mutex
+	var reports []ScanProgress
+	c := db.C("events")
+	opts := ScanOptions{Workers: 3, Progress: func(p ScanProgress) {
+		reports = append(reports, p)
+	}}
+	seen := make(map[int]int)
+	scan := func(filter interface{}) error {
+		seen = make(map[int]int)
+		reports = nil
+		return c.ParallelScan(filter, opts, func(doc bson.Raw) error {
+			var event struct {
+				Id int `bson:"_id"`
+			}
+			if err := doc.Unmarshal(&event); err != nil {
+				return err
+			}
+			mu.Lock()
+			seen[event.Id]++
+			mu.Unlock()
+			return nil
+		})
+	}
+
+	if err := scan(bson.M{"odd": true}); err != nil {
+		t.Fatal(err)
+	}
+	if srv.Received("splitVector") == 0 {
+		t.Fatal("splitVector not used")
+	}
+	if len(seen) != 50 {
+		t.Fatalf("%d documents seen", len(seen))
+	}
+	for id, n := range seen {
+		if id%2 == 0 || n != 1 {
+			t.Fatalf("document %d seen %d times", id, n)
+		}
+	}
+	last := reports[len(reports)-1]
+	if last.Ranges < 2 || last.Done != last.Ranges || last.Docs != 50 || len(reports) != last.Ranges {
+		t.Fatalf("progress: %+v", reports)
+	}
+
+	// Without splitVector, the ranges come from $sample, and broken
+	// cursors resume.
+	srv.DropNext("splitVector", 2)
+	srv.DropNext("getMore", 1)
+	opts.Progress = nil
+	if err := scan(nil); err != nil {
+		t.Fatal(err)
+	}
+	if srv.Received("aggregate") == 0 {
+		t.Fatal("_id values not sampled")
+	}
+	if len(seen) != 100 {
+		t.Fatalf("%d documents seen with sampling", len(seen))
+	}
+	for id, n := range seen {
+		if n != 1 {
+			t.Fatalf("document %d seen %d times with sampling", id, n)
+		}
+	}
+
+	// Comparisons only match _id values of the type of the bound, so every
+	// type gets its own ranges, and a last range holds the unsampled types.
+	for i := 0; i < 30; i++ {
+		if err := db.C("events").Insert(bson.M{"_id": fmt.Sprintf("e%02d", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.C("events").Insert(bson.M{"_id": bson.NewObjectId()}, bson.M{"_id": true}); err != nil {
+		t.Fatal(err)
+	}
+	for _, sampled := range []bool{false, true} {
+		if sampled {
+			srv.DropNext("splitVector", 2)
+		}
+		var mixed int64
+		err := c.ParallelScan(nil, ScanOptions{Workers: 3}, func(doc bson.Raw) error {
+			atomic.AddInt64(&mixed, 1)
+			return nil
+		})
+		if err != nil || mixed != 132 {
+			t.Fatalf("scanned %d documents with mixed _id types (sampled %v), %v; want 132", mixed, sampled, err)
+		}
+	}
+
+	srv.DropNext("splitVector", 2)
+	srv.DropNext("aggregate", db.MaxConnectRetries)
+	if err := c.ParallelScan(nil, ScanOptions{Workers: 3}, func(bson.Raw) error { return nil }); err == nil {
+		t.Fatal("failure to split the collection not reported")
+	}
+
+	stop := errors.New("stop")
+	err := c.ParallelScan(nil, ScanOptions{Workers: 2}, func(doc bson.Raw) error { return stop })
+	if err != stop {
+		t.Fatalf("got %v, want the scan function's error", err)
+	}
+
+	// A range interrupted by the failure of another is not done.
+	reports = nil
+	opts = ScanOptions{Workers: 2, Progress: func(p ScanProgress) {
+		reports = append(reports, p)
+	}}
+	err = c.ParallelScan(nil, opts, func(doc bson.Raw) error {
+		var event struct {
+			Id interface{} `bson:"_id"`
+		}
+		if err := doc.Unmarshal(&event); err != nil {
+			return err
+		}
+		if event.Id == 0 {
+			return stop
+		}
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	})
+	if err != stop || len(reports) != 0 {
+		t.Fatalf("interrupted scan: %v, progress %+v", err, reports)
+	}
+}
@@ -0,0 +1,138 @@
+package mdb
+
+import (
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+// softDelete holds the soft-delete mode of a Collection.
+type softDelete struct {
+	field string
+	scope int
+}
+
+const (
+	liveDocs = iota
+	allDocs
+	deletedDocs
+)
+
+// WithSoftDelete returns a copy of the collection in soft-delete mode, where
+// documents are marked as deleted by setting field, "deletedAt" by default,
+// to the time of deletion instead of being removed:
+//
+//     - Remove, RemoveId, RemoveAll and Query.Apply with Remove set mark the
+//       matching documents as deleted;
+//     - Find, FindId and Count ignore documents marked as deleted;
+//     - Restore unmarks deleted documents and Purge removes them for good.
+//
+// Use WithDeleted or OnlyDeleted to query deleted documents. Updates and
+// pipelines are not affected by the mode.
+//
+// For example:
+//
+//     people := db.C("people").WithSoftDelete("")
+//     err := people.RemoveId(id)
+//     err = people.FindId(id).One(&p) // mgo.ErrNotFound
+//     err = people.WithDeleted().FindId(id).One(&p)
+//
+func (c *Collection) WithSoftDelete(field string) *Collection {
+	if field == "" {
+		field = "deletedAt"
+	}
+	cc := *c
+	cc.softDelete = &softDelete{field: field}
+	return &cc
+}
+
+// WithDeleted returns a copy of a soft-delete collection whose queries also
+// return the documents marked as deleted.
+func (c *Collection) WithDeleted() *Collection {
+	return c.softDeleteScope(allDocs)
+}
+
+// OnlyDeleted returns a copy of a soft-delete collection whose queries only
+// return the documents marked as deleted.
+func (c *Collection) OnlyDeleted() *Collection {
+	return c.softDeleteScope(deletedDocs)
+}
+
+func (c *Collection) softDeleteScope(scope int) *Collection {
+	if c.softDelete == nil {
+		return c
+	}
+	cc := *c
+	cc.softDelete = &softDelete{field: c.softDelete.field, scope: scope}
+	return &cc
+}
+
+// Restore unmarks the deleted documents matching selector, and is a no-op
+// unless the collection is in soft-delete mode.
+func (c *Collection) Restore(selector interface{}) (info *mgo.ChangeInfo, err error) {
+	if c.softDelete == nil {
+		return &mgo.ChangeInfo{}, nil
+	}
+	return c.UpdateAll(c.softDelete.filter(selector, deletedDocs), bson.D{
+		{Name: "$unset", Value: bson.D{{Name: c.softDelete.field, Value: ""}}},
+	})
+}
+
+// Purge removes for good the deleted documents matching selector, and is a
+// no-op unless the collection is in soft-delete mode.
+func (c *Collection) Purge(selector interface{}) (info *mgo.ChangeInfo, err error) {
+	if c.softDelete == nil {
+		return &mgo.ChangeInfo{}, nil
+	}
+	cc := *c
+	cc.softDelete = nil
+	return cc.RemoveAll(c.softDelete.filter(selector, deletedDocs))
+}
+
+// deletion returns the update marking documents as deleted.
+func (c *Collection) deletion() bson.D {
+	now := time.Now
+	if c.timestamps != nil {
+		now = c.timestamps.Now
+	}
+	return bson.D{
+		{Name: "$set", Value: bson.D{{Name: c.softDelete.field, Value: now().Truncate(time.Millisecond)}}},
+	}
+}
+
+// softDeleteChange turns a removal by Query.Apply into a deletion mark.
+func (c *Collection) softDeleteChange(change mgo.Change) mgo.Change {
+	if c.softDelete == nil || !change.Remove {
+		return change
+	}
+	return mgo.Change{Update: c.deletion()}
+}
+
+// filter restricts query to the documents of the given scope.
+func (s *softDelete) filter(query interface{}, scope int) interface{} {
+	if s == nil || scope == allDocs {
+		return query
+	}
+	cond := bson.DocElem{Name: s.field, Value: nil}
+	if scope == deletedDocs {
+		cond.Value = bson.D{{Name: "$ne", Value: nil}}
+	}
+	if query == nil {
+		return bson.D{cond}
+	}
+	d, err := toDoc(query)
+	if err != nil || hasField(d, s.field) {
+		return bson.D{{Name: "$and", Value: []interface{}{query, bson.D{cond}}}}
+	}
+	return append(d, cond)
+}
+
+func hasField(d bson.D, name string) bool {
+	for _, e := range d {
+		if e.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+package mdb
+
+import (
+	"testing"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+func TestSoftDelete(t *testing.T) {
+	_, db := dialTest(t)
+	c := db.C("people").WithSoftDelete("")
+	if err := c.Insert(bson.M{"_id": 1, "age": 20}, bson.M{"_id": 2, "age": 30}, bson.M{"_id": 3, "age": 40}); err != nil {
+		t.Fatal(err)
+	}
+	count := func(c *Collection) int {
+		t.Helper()
+		n, err := c.Count()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	if err := c.RemoveId(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.RemoveId(1); err != mgo.ErrNotFound {
+		t.Fatalf("second remove: %v", err)
+	}
+	if err := c.FindId(1).One(&bson.M{}); err != mgo.ErrNotFound {
+		t.Fatalf("find deleted: %v", err)
+	}
+	var doc bson.M
+	if err := c.WithDeleted().FindId(1).One(&doc); err != nil || doc["deletedAt"] == nil {
+		t.Fatalf("find with deleted: %v %v", doc, err)
+	}
+	if n, err := db.C("people").Count(); err != nil || n != 3 {
+		t.Fatalf("documents were removed: %d %v", n, err)
+	}
+
+	info, err := c.RemoveAll(bson.M{"age": bson.M{"$gte": 30}})
+	if err != nil || info.Updated != 2 {
+		t.Fatalf("remove all: %+v %v", info, err)
+	}
+	if n := count(c); n != 0 {
+		t.Fatalf("count: %d", n)
+	}
+	if n := count(c.OnlyDeleted()); n != 3 {
+		t.Fatalf("deleted count: %d", n)
+	}
+
+	if info, err = c.Restore(bson.M{"_id": 2}); err != nil || info.Updated != 1 {
+		t.Fatalf("restore: %+v %v", info, err)
+	}
+	if err := c.FindId(2).One(&bson.M{}); err != nil {
+		t.Fatalf("find restored: %v", err)
+	}
+
+	var removed bson.M
+	if _, err := c.FindId(2).Apply(mgo.Change{Remove: true}, &removed); err != nil || removed["_id"] != 2 {
+		t.Fatalf("apply remove: %v %v", removed, err)
+	}
+	if n := count(c.OnlyDeleted()); n != 3 {
+		t.Fatalf("deleted count after apply: %d", n)
+	}
+
+	if info, err = c.Purge(bson.M{"age": bson.M{"$lt": 40}}); err != nil || info.Removed != 2 {
+		t.Fatalf("purge: %+v %v", info, err)
+	}
+	if n := count(c.WithDeleted()); n != 1 {
+		t.Fatalf("count after purge: %d", n)
+	}
+
+	if err := c.Truncate(); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(c.WithDeleted()); n != 0 {
+		t.Fatalf("count after truncate: %d", n)
+	}
+}
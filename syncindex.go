@@ -0,0 +1,255 @@
+package mdb
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+// SyncIndexesOptions configures Collection.SyncIndexes.
+type SyncIndexesOptions struct {
+	// Apply creates missing indexes and rebuilds changed ones. When false,
+	// SyncIndexes only reports the differences.
+	Apply bool
+	// DropExtra drops, when applying, the indexes of the collection which are
+	// not desired. The _id index is never dropped.
+	DropExtra bool
+}
+
+// IndexChange describes an existing index whose options differ from the
+// desired ones.
+type IndexChange struct {
+	Current     mgo.Index
+	Desired     mgo.Index
+	Differences []string
+}
+
+// IndexSyncReport is the result of Collection.SyncIndexes.
+type IndexSyncReport struct {
+	Collection string
+	Missing    []mgo.Index   // Desired indexes which do not exist.
+	Extra      []mgo.Index   // Existing indexes which are not desired.
+	Changed    []IndexChange // Existing indexes whose options differ.
+	Applied    bool          // Whether the changes were made.
+}
+
+// InSync reports whether the collection indexes match the desired ones,
+// ignoring extra indexes.
+func (r *IndexSyncReport) InSync() bool {
+	return len(r.Missing) == 0 && len(r.Changed) == 0
+}
+
+// String returns a readable summary of the report, one change per line,
+// suitable for reviewing index changes in deploy pipelines.
+func (r *IndexSyncReport) String() string {
+	var b strings.Builder
+	verb := "would"
+	if r.Applied {
+		verb = "did"
+	}
+	fmt.Fprintf(&b, "indexes of %s: %d missing, %d changed, %d extra\n", r.Collection, len(r.Missing), len(r.Changed), len(r.Extra))
+	for _, index := range r.Missing {
+		fmt.Fprintf(&b, "+ %s %v (%s create)\n", IndexName(index), index.Key, verb)
+	}
+	for _, change := range r.Changed {
+		fmt.Fprintf(&b, "~ %s %v (%s rebuild): %s\n", change.Current.Name, change.Desired.Key, verb, strings.Join(change.Differences, ", "))
+	}
+	for _, index := range r.Extra {
+		fmt.Fprintf(&b, "- %s %v\n", index.Name, index.Key)
+	}
+	return b.String()
+}
+
+// SyncIndexes compares the desired indexes against the ones of the
+// collection, and reports the indexes which are missing, extra, or whose
+// options changed. Indexes are matched by name, using the name MongoDB
+// derives from the key when the desired index has none, or else by key.
+//
+// With opts.Apply set, missing indexes are created and changed indexes are
+// dropped and created again with the desired options; with opts.DropExtra
+// also set, extra indexes are dropped via DropIndexName.
+//
+// For example, to review and then apply the indexes of a model:
+//
+//     desired, err := mdb.IndexesFor(Person{})
+//     report, err := c.SyncIndexes(desired, mdb.SyncIndexesOptions{})
+//     fmt.Print(report)
+//     report, err = c.SyncIndexes(desired, mdb.SyncIndexesOptions{Apply: true})
+//
+func (c *Collection) SyncIndexes(desired []mgo.Index, opts SyncIndexesOptions) (*IndexSyncReport, error) {
+	current, err := c.Indexes()
+	if err != nil && !isNamespaceNotFound(err) {
+		return nil, err
+	}
+	report := &IndexSyncReport{Collection: c.Name}
+	matched := make([]bool, len(current))
+	for _, want := range desired {
+		i := findIndex(current, want)
+		if i < 0 {
+			report.Missing = append(report.Missing, want)
+			continue
+		}
+		matched[i] = true
+		if diff := indexDifferences(current[i], want); len(diff) > 0 {
+			report.Changed = append(report.Changed, IndexChange{Current: current[i], Desired: want, Differences: diff})
+		}
+	}
+	for i, index := range current {
+		if !matched[i] && index.Name != "_id_" {
+			report.Extra = append(report.Extra, index)
+		}
+	}
+	if !opts.Apply {
+		return report, nil
+	}
+	for _, change := range report.Changed {
+		if err := c.DropIndexName(change.Current.Name); err != nil {
+			return report, err
+		}
+		if err := c.EnsureIndex(change.Desired); err != nil {
+			return report, err
+		}
+	}
+	for _, index := range report.Missing {
+		if err := c.EnsureIndex(index); err != nil {
+			return report, err
+		}
+	}
+	if opts.DropExtra {
+		for _, index := range report.Extra {
+			if err := c.DropIndexName(index.Name); err != nil {
+				return report, err
+			}
+		}
+	}
+	report.Applied = true
+	return report, nil
+}
+
+// IndexName returns the name of index: its Name field if set, or else the
+// name MongoDB derives from its key, such as "lastname_1_firstname_-1".
+func IndexName(index mgo.Index) string {
+	if index.Name != "" {
+		return index.Name
+	}
+	parts := make([]string, len(index.Key))
+	for i, field := range index.Key {
+		switch {
+		case strings.HasPrefix(field, "$"):
+			if c := strings.Index(field, ":"); c > 1 {
+				parts[i] = field[c+1:] + "_" + field[1:c]
+				continue
+			}
+			parts[i] = field
+		case strings.HasPrefix(field, "@"):
+			parts[i] = field[1:] + "_2d"
+		case strings.HasPrefix(field, "-"):
+			parts[i] = field[1:] + "_-1"
+		default:
+			parts[i] = strings.TrimPrefix(field, "+") + "_1"
+		}
+	}
+	return strings.Join(parts, "_")
+}
+
+// findIndex returns the position in current of the index matching want, or
+// -1 if there is none.
+func findIndex(current []mgo.Index, want mgo.Index) int {
+	name := IndexName(want)
+	for i, index := range current {
+		if index.Name == name {
+			return i
+		}
+	}
+	if want.Name == "" {
+		for i, index := range current {
+			if reflect.DeepEqual(normalizeKey(index.Key), normalizeKey(want.Key)) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func normalizeKey(key []string) []string {
+	out := make([]string, len(key))
+	for i, field := range key {
+		out[i] = strings.TrimPrefix(field, "+")
+	}
+	return out
+}
+
+// indexDifferences lists the options of want which differ in the existing
+// index. Options left unset in want, such as the text index language, are
+// not compared.
+func indexDifferences(current, want mgo.Index) (diff []string) {
+	add := func(option string, from, to interface{}) {
+		diff = append(diff, fmt.Sprintf("%s: %v -> %v", option, from, to))
+	}
+	if !reflect.DeepEqual(normalizeKey(current.Key), normalizeKey(want.Key)) {
+		add("key", current.Key, want.Key)
+	}
+	if current.Unique != want.Unique {
+		add("unique", current.Unique, want.Unique)
+	}
+	if current.Sparse != want.Sparse {
+		add("sparse", current.Sparse, want.Sparse)
+	}
+	if current.ExpireAfter != want.ExpireAfter {
+		add("expireAfter", current.ExpireAfter, want.ExpireAfter)
+	}
+	if !samePartialFilter(current.PartialFilter, want.PartialFilter) {
+		add("partialFilter", current.PartialFilter, want.PartialFilter)
+	}
+	if want.Bits != 0 && current.Bits != want.Bits {
+		add("bits", current.Bits, want.Bits)
+	}
+	if (want.Min != 0 || want.Max != 0) && (current.Min != want.Min || current.Max != want.Max) {
+		add("bounds", [2]int{current.Min, current.Max}, [2]int{want.Min, want.Max})
+	}
+	if (want.Minf != 0 || want.Maxf != 0) && (current.Minf != want.Minf || current.Maxf != want.Maxf) {
+		add("bounds", [2]float64{current.Minf, current.Maxf}, [2]float64{want.Minf, want.Maxf})
+	}
+	if want.BucketSize != 0 && current.BucketSize != want.BucketSize {
+		add("bucketSize", current.BucketSize, want.BucketSize)
+	}
+	if want.DefaultLanguage != "" && current.DefaultLanguage != want.DefaultLanguage {
+		add("defaultLanguage", current.DefaultLanguage, want.DefaultLanguage)
+	}
+	if want.LanguageOverride != "" && current.LanguageOverride != want.LanguageOverride {
+		add("languageOverride", current.LanguageOverride, want.LanguageOverride)
+	}
+	if want.Weights != nil && !reflect.DeepEqual(current.Weights, want.Weights) {
+		add("weights", current.Weights, want.Weights)
+	}
+	if want.Collation != nil && (current.Collation == nil ||
+		current.Collation.Locale != want.Collation.Locale ||
+		want.Collation.Strength != 0 && current.Collation.Strength != want.Collation.Strength) {
+		add("collation", current.Collation, want.Collation)
+	}
+	return diff
+}
+
+// samePartialFilter compares two filters after a bson round trip, so that
+// numeric types decoded from the server match the ones written in Go.
+func samePartialFilter(a, b bson.M) bool {
+	if len(a) == 0 || len(b) == 0 {
+		return len(a) == len(b)
+	}
+	return reflect.DeepEqual(roundTrip(a), roundTrip(b))
+}
+
+func roundTrip(m bson.M) interface{} {
+	data, err := bson.Marshal(m)
+	if err != nil {
+		return m
+	}
+	var out bson.M
+	if err := bson.Unmarshal(data, &out); err != nil {
+		return m
+	}
+	return out
+}
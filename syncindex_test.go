@@ -0,0 +1,60 @@
+package mdb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo"
+)
+
+func TestSyncIndexes(t *testing.T) {
+	_, db := dialTest(t)
+	c := db.C("people")
+	for _, index := range []mgo.Index{
+		{Key: []string{"email"}},
+		{Key: []string{"session"}, ExpireAfter: time.Hour},
+		{Key: []string{"legacy"}},
+	} {
+		if err := c.EnsureIndex(index); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	desired := []mgo.Index{
+		{Key: []string{"email"}, Unique: true},
+		{Key: []string{"session"}, ExpireAfter: time.Hour},
+		{Key: []string{"lastName", "-firstName"}},
+	}
+	report, err := c.SyncIndexes(desired, SyncIndexesOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Applied || len(report.Missing) != 1 || len(report.Changed) != 1 || len(report.Extra) != 1 {
+		t.Fatalf("unexpected dry run report:\n%s", report)
+	}
+	if report.Missing[0].Key[0] != "lastName" || report.Changed[0].Current.Name != "email_1" || report.Extra[0].Name != "legacy_1" {
+		t.Fatalf("unexpected dry run report:\n%s", report)
+	}
+
+	report, err = c.SyncIndexes(desired, SyncIndexesOptions{Apply: true, DropExtra: true})
+	if err != nil || !report.Applied {
+		t.Fatalf("apply failed: %v\n%s", err, report)
+	}
+	report, err = c.SyncIndexes(desired, SyncIndexesOptions{})
+	if err != nil || !report.InSync() || len(report.Extra) != 0 {
+		t.Fatalf("indexes not in sync after apply: %v\n%s", err, report)
+	}
+}
+
+func TestIndexName(t *testing.T) {
+	for key, want := range map[string]string{
+		"a":       "a_1",
+		"-a":      "a_-1",
+		"$text:a": "a_text",
+		"@loc":    "loc_2d",
+	} {
+		if got := IndexName(mgo.Index{Key: []string{key, "b"}}); got != want+"_b_1" {
+			t.Errorf("IndexName(%q) = %q, want %q", key, got, want+"_b_1")
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package mdb
+
+import (
+	"strings"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+// TimestampOptions configures Collection.WithTimestamps.
+type TimestampOptions struct {
+	CreatedAt string           // Field set when a document is inserted, "createdAt" by default.
+	UpdatedAt string           // Field set whenever a document is written, "updatedAt" by default.
+	Now       func() time.Time // Clock used for both fields, time.Now by default.
+}
+
+// WithTimestamps returns a copy of the collection which maintains creation
+// and modification times of its documents:
+//
+//     - Insert sets both fields on every document, unless the creation time
+//       is already set;
+//     - Upsert and UpsertId set the creation time with $setOnInsert;
+//     - Update, UpdateId, UpdateAll, Upsert, UpsertId and Query.Apply set the
+//       modification time with $set.
+//
+// Replacement documents have the modification time set in place; as they
+// replace the whole stored document, they must carry the creation time
+// themselves. Replacements given to Upsert, UpsertId or an upserting
+// Query.Apply without a creation time are sent as a $set of their fields
+// instead, which keeps the creation time of a matched document, but also
+// its fields missing from the replacement.
+//
+// For example:
+//
+//     people := db.C("people").WithTimestamps(mdb.TimestampOptions{})
+//     err := people.UpdateId(id, bson.M{"$set": bson.M{"name": "Ale"}})
+//
+func (c *Collection) WithTimestamps(opts TimestampOptions) *Collection {
+	if opts.CreatedAt == "" {
+		opts.CreatedAt = "createdAt"
+	}
+	if opts.UpdatedAt == "" {
+		opts.UpdatedAt = "updatedAt"
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	cc := *c
+	cc.timestamps = &opts
+	return &cc
+}
+
+// now returns the current time as stored by MongoDB, in milliseconds.
+func (t *TimestampOptions) now() time.Time {
+	return t.Now().Truncate(time.Millisecond)
+}
+
+// stampInsert returns docs with the creation and modification times set.
+func (t *TimestampOptions) stampInsert(docs []interface{}) ([]interface{}, error) {
+	if t == nil {
+		return docs, nil
+	}
+	now := t.now()
+	out := make([]interface{}, len(docs))
+	for i, doc := range docs {
+		d, err := toDoc(doc)
+		if err != nil {
+			return nil, err
+		}
+		if isZeroValue(docValue(d, t.CreatedAt)) {
+			d = setDocField(d, t.CreatedAt, now)
+		}
+		out[i] = setDocField(d, t.UpdatedAt, now)
+	}
+	return out, nil
+}
+
+// stampUpdate returns update with the modification time set, and the
+// creation time set on insert when upsert is true.
+func (t *TimestampOptions) stampUpdate(update interface{}, upsert bool) (interface{}, error) {
+	if t == nil || update == nil {
+		return update, nil
+	}
+	d, err := toDoc(update)
+	if err != nil {
+		return nil, err
+	}
+	now := t.now()
+	if !isOperatorDoc(d) {
+		if upsert && isZeroValue(docValue(d, t.CreatedAt)) {
+			return t.upsertReplacement(d, now), nil
+		}
+		return setDocField(d, t.UpdatedAt, now), nil
+	}
+	if !updateTouches(d, t.UpdatedAt) {
+		if d, err = addToOperator(d, "$set", t.UpdatedAt, now); err != nil {
+			return nil, err
+		}
+	}
+	if upsert && !updateTouches(d, t.CreatedAt) {
+		if d, err = addToOperator(d, "$setOnInsert", t.CreatedAt, now); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// upsertReplacement turns the replacement document of an upsert without a
+// creation time into a $set of its fields, as only $setOnInsert can set the
+// creation time of inserted documents without overwriting that of replaced
+// ones. _id is also set on insert only.
+func (t *TimestampOptions) upsertReplacement(d bson.D, now time.Time) bson.D {
+	set := bson.D{}
+	onInsert := bson.D{{Name: t.CreatedAt, Value: now}}
+	for _, e := range d {
+		switch e.Name {
+		case "_id":
+			onInsert = append(onInsert, e)
+		case t.CreatedAt, t.UpdatedAt:
+		default:
+			set = append(set, e)
+		}
+	}
+	set = append(set, bson.DocElem{Name: t.UpdatedAt, Value: now})
+	return bson.D{{Name: "$set", Value: set}, {Name: "$setOnInsert", Value: onInsert}}
+}
+
+// stampChange returns change with its update document stamped.
+func (t *TimestampOptions) stampChange(change mgo.Change) (mgo.Change, error) {
+	if t == nil || change.Remove {
+		return change, nil
+	}
+	update, err := t.stampUpdate(change.Update, change.Upsert)
+	change.Update = update
+	return change, err
+}
+
+// isOperatorDoc reports whether an update document uses $ operators rather
+// than being a replacement document.
+func isOperatorDoc(d bson.D) bool {
+	return len(d) > 0 && strings.HasPrefix(d[0].Name, "$")
+}
+
+// updateTouches reports whether any operator of update modifies field.
+func updateTouches(update bson.D, field string) bool {
+	for _, op := range update {
+		fields, _ := toDoc(op.Value)
+		for _, f := range fields {
+			if f.Name == field || strings.HasPrefix(f.Name, field+".") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// addToOperator sets field to value under the given update operator,
+// adding the operator if needed. The operator's fields are copied into a
+// new bson.D, whatever the type of document holding them.
+func addToOperator(update bson.D, op, field string, value interface{}) (bson.D, error) {
+	for i, e := range update {
+		if e.Name == op {
+			fields, err := toDoc(e.Value)
+			if err != nil {
+				return nil, err
+			}
+			update[i].Value = setDocField(fields, field, value)
+			return update, nil
+		}
+	}
+	return append(update, bson.DocElem{Name: op, Value: bson.D{{Name: field, Value: value}}}), nil
+}
+
+// toDoc converts a document of any type bson can marshal into a new
+// bson.D. Only the top level is copied: nested documents are left as they
+// are.
+func toDoc(doc interface{}) (bson.D, error) {
+	if d, ok := doc.(bson.D); ok {
+		return append(bson.D(nil), d...), nil
+	}
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	var d bson.D
+	err = bson.Unmarshal(data, &d)
+	return d, err
+}
+
+// docValue returns the value of the top level field name of d, or nil.
+func docValue(d bson.D, name string) interface{} {
+	for _, e := range d {
+		if e.Name == name {
+			return e.Value
+		}
+	}
+	return nil
+}
+
+// setDocField sets the top level field name of d, appending it if missing.
+func setDocField(d bson.D, name string, value interface{}) bson.D {
+	for i, e := range d {
+		if e.Name == name {
+			d[i].Value = value
+			return d
+		}
+	}
+	return append(d, bson.DocElem{Name: name, Value: value})
+}
+
+func isZeroValue(v interface{}) bool {
+	switch t := v.(type) {
+	case nil:
+		return true
+	case time.Time:
+		return t.IsZero()
+	}
+	return false
+}
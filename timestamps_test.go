@@ -0,0 +1,134 @@
+package mdb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+type stampedDoc struct {
+	Id        int       `bson:"_id"`
+	Name      string    `bson:"name"`
+	CreatedAt time.Time `bson:"created"`
+	UpdatedAt time.Time `bson:"updated"`
+}
+
+func TestTimestamps(t *testing.T) {
+	_, db := dialTest(t)
+	now := time.Date(2018, 6, 11, 10, 0, 0, 0, time.UTC)
+	c := db.C("docs").WithTimestamps(TimestampOptions{
+		CreatedAt: "created",
+		UpdatedAt: "updated",
+		Now:       func() time.Time { return now },
+	})
+	load := func(id int) (doc stampedDoc) {
+		t.Helper()
+		if err := c.FindId(id).One(&doc); err != nil {
+			t.Fatal(err)
+		}
+		doc.CreatedAt, doc.UpdatedAt = doc.CreatedAt.UTC(), doc.UpdatedAt.UTC()
+		return doc
+	}
+	created := now
+	if err := c.Insert(&stampedDoc{Id: 1, Name: "Ale"}, bson.M{"_id": 2}); err != nil {
+		t.Fatal(err)
+	}
+	if doc := load(1); !doc.CreatedAt.Equal(created) || !doc.UpdatedAt.Equal(created) {
+		t.Fatalf("insert: %+v", doc)
+	}
+
+	now = now.Add(time.Hour)
+	if err := c.UpdateId(1, bson.M{"$set": bson.M{"name": "Cla"}}); err != nil {
+		t.Fatal(err)
+	}
+	if doc := load(1); doc.Name != "Cla" || !doc.CreatedAt.Equal(created) || !doc.UpdatedAt.Equal(now) {
+		t.Fatalf("update: %+v", doc)
+	}
+
+	now = now.Add(time.Hour)
+	if _, err := c.UpdateAll(nil, bson.M{"$inc": bson.M{"n": 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if doc := load(2); !doc.CreatedAt.Equal(created) || !doc.UpdatedAt.Equal(now) {
+		t.Fatalf("update all: %+v", doc)
+	}
+
+	now = now.Add(time.Hour)
+	if _, err := c.UpsertId(3, bson.M{"$set": bson.M{"name": "Dan"}}); err != nil {
+		t.Fatal(err)
+	}
+	if doc := load(3); !doc.CreatedAt.Equal(now) || !doc.UpdatedAt.Equal(now) {
+		t.Fatalf("upsert insert: %+v", doc)
+	}
+	upserted := now
+	now = now.Add(time.Hour)
+	if _, err := c.UpsertId(3, bson.M{"$set": bson.M{"name": "Eva"}}); err != nil {
+		t.Fatal(err)
+	}
+	if doc := load(3); !doc.CreatedAt.Equal(upserted) || !doc.UpdatedAt.Equal(now) {
+		t.Fatalf("upsert update: %+v", doc)
+	}
+
+	now = now.Add(time.Hour)
+	if _, err := c.UpsertId(3, &stampedDoc{Id: 3, Name: "Ivy"}); err != nil {
+		t.Fatal(err)
+	}
+	if doc := load(3); doc.Name != "Ivy" || !doc.CreatedAt.Equal(upserted) || !doc.UpdatedAt.Equal(now) {
+		t.Fatalf("replacement upsert update: %+v", doc)
+	}
+	if _, err := c.Upsert(bson.M{"name": "Joe"}, &stampedDoc{Id: 5, Name: "Joe"}); err != nil {
+		t.Fatal(err)
+	}
+	if doc := load(5); doc.Name != "Joe" || !doc.CreatedAt.Equal(now) || !doc.UpdatedAt.Equal(now) {
+		t.Fatalf("replacement upsert insert: %+v", doc)
+	}
+
+	now = now.Add(time.Hour)
+	doc := load(1)
+	doc.Name = "Fede"
+	if err := c.UpdateId(1, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc := load(1); doc.Name != "Fede" || !doc.CreatedAt.Equal(created) || !doc.UpdatedAt.Equal(now) {
+		t.Fatalf("replace: %+v", doc)
+	}
+
+	now = now.Add(time.Hour)
+	var result stampedDoc
+	change := mgo.Change{Update: bson.M{"$set": bson.M{"name": "Gio"}}, ReturnNew: true}
+	if _, err := c.FindId(2).Apply(change, &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Name != "Gio" || !result.UpdatedAt.Equal(now) {
+		t.Fatalf("apply: %+v", result)
+	}
+
+	now = now.Add(time.Hour)
+	set := bson.D{{Name: "$set", Value: bson.M{"name": "Hal"}}}
+	if err := c.UpdateId(1, set); err != nil {
+		t.Fatal(err)
+	}
+	if doc := load(1); doc.Name != "Hal" || !doc.UpdatedAt.Equal(now) {
+		t.Fatalf("update with nested bson.M: %+v", doc)
+	}
+	if _, ok := set[0].Value.(bson.M)["updated"]; ok {
+		t.Fatal("update document modified")
+	}
+	stamped := now.Add(-time.Minute)
+	set = bson.D{{Name: "$set", Value: bson.M{"updated": stamped}}}
+	if err := c.UpdateId(1, set); err != nil {
+		t.Fatal(err)
+	}
+	if doc := load(1); !doc.UpdatedAt.Equal(stamped) {
+		t.Fatalf("explicit modification time in nested bson.M: %+v", doc)
+	}
+
+	if err := db.C("docs").Insert(bson.M{"_id": 4}); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := db.C("docs").Find(bson.M{"_id": 4, "created": bson.M{"$exists": true}}).Count(); n != 0 {
+		t.Fatal("collection without timestamps stamped a document")
+	}
+}
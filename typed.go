@@ -0,0 +1,111 @@
+package mdb
+
+import (
+	"github.com/globalsign/mgo/bson"
+)
+
+// TypedCollection is a Collection whose documents decode into values of
+// type T, so that mistakes in result types are caught at compile time.
+//
+// The untyped methods of the embedded Collection remain available. The
+// typed finders only fetch the fields decoded by T; see ProjectionFor.
+//
+// For example:
+//
+//     people := mdb.Typed[Person](db.C("people"))
+//     err := people.Insert(Person{Name: "Ale"})
+//     ale, err := people.FindOne(bson.M{"name": "Ale"})
+//
+type TypedCollection[T any] struct {
+	*Collection
+}
+
+// Typed returns a TypedCollection holding documents of type T in c.
+func Typed[T any](c *Collection) *TypedCollection[T] {
+	return &TypedCollection[T]{Collection: c}
+}
+
+// FindOne returns the first document matching filter. If no document
+// matches, the zero T and mgo.ErrNotFound are returned.
+func (c *TypedCollection[T]) FindOne(filter interface{}) (doc T, err error) {
+	err = c.find(filter).One(&doc)
+	return doc, err
+}
+
+// FindId returns the document with the given _id.
+func (c *TypedCollection[T]) FindId(id interface{}) (T, error) {
+	return c.FindOne(bson.D{{Name: "_id", Value: id}})
+}
+
+// FindAll returns every document matching filter.
+func (c *TypedCollection[T]) FindAll(filter interface{}) (docs []T, err error) {
+	err = c.find(filter).All(&docs)
+	return docs, err
+}
+
+// Insert inserts one or more documents. See Collection.Insert.
+func (c *TypedCollection[T]) Insert(docs ...T) error {
+	list := make([]interface{}, len(docs))
+	for i := range docs {
+		list[i] = &docs[i]
+	}
+	return c.Collection.Insert(list...)
+}
+
+// UpdateId replaces the document with the given _id by doc. Update
+// operators such as $set are still available through c.Collection.UpdateId.
+func (c *TypedCollection[T]) UpdateId(id interface{}, doc T) error {
+	return c.Collection.UpdateId(id, &doc)
+}
+
+// Iter returns an iterator over the documents matching filter.
+func (c *TypedCollection[T]) Iter(filter interface{}) *TypedIter[T] {
+	return &TypedIter[T]{Iter: c.find(filter).Iter()}
+}
+
+// find prepares a query for filter selecting the fields of T.
+func (c *TypedCollection[T]) find(filter interface{}) *Query {
+	return c.Find(filter).SelectFor(new(T))
+}
+
+// TypedIter is an Iter yielding values of type T.
+type TypedIter[T any] struct {
+	*Iter
+}
+
+// Next returns the next document of the result set, and false at its end
+// or if an error happened. See Iter.Next.
+//
+// For example:
+//
+//     iter := people.Iter(nil)
+//     for p, ok := iter.Next(); ok; p, ok = iter.Next() {
+//         fmt.Println(p.Name)
+//     }
+//     if err := iter.Close(); err != nil {
+//         return err
+//     }
+//
+func (iter *TypedIter[T]) Next() (doc T, ok bool) {
+	ok = iter.Iter.Next(&doc)
+	return doc, ok
+}
+
+// All returns every remaining document and closes the iterator.
+func (iter *TypedIter[T]) All() (docs []T, err error) {
+	err = iter.Iter.All(&docs)
+	return docs, err
+}
+
+// Distinct returns the distinct values of key among the documents of c
+// matching filter, decoded as values of type V.
+//
+// For example:
+//
+//     ages, err := mdb.Distinct[Person, int](people, "age", bson.M{"gender": "F"})
+//
+func Distinct[T, V any](c *TypedCollection[T], key string, filter interface{}) (values []V, err error) {
+	err = c.Find(filter).Distinct(key, &values)
+	return values, err
+}
+
@@ -0,0 +1,60 @@
+package mdb
+
+import (
+	"testing"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+type typedPerson struct {
+	Id   bson.ObjectId `bson:"_id"`
+	Name string
+	Age  int
+}
+
+func TestTypedCollection(t *testing.T) {
+	_, db := dialTest(t)
+	people := Typed[typedPerson](db.C("people"))
+
+	ale := typedPerson{Id: bson.NewObjectId(), Name: "Ale", Age: 30}
+	err := people.Insert(ale, typedPerson{Id: bson.NewObjectId(), Name: "Cla", Age: 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := people.FindOne(bson.M{"name": "Ale"})
+	if err != nil || got != ale {
+		t.Fatalf("got %+v, %v; want %+v", got, err, ale)
+	}
+	if _, err := people.FindOne(bson.M{"name": "Bob"}); err != mgo.ErrNotFound {
+		t.Fatalf("got %v, want not found", err)
+	}
+
+	ale.Age = 31
+	if err := people.UpdateId(ale.Id, ale); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := people.FindId(ale.Id); err != nil || got.Age != 31 {
+		t.Fatalf("got %+v, %v; want age 31", got, err)
+	}
+
+	all, err := people.FindAll(nil)
+	if err != nil || len(all) != 2 {
+		t.Fatalf("got %d documents, %v; want 2", len(all), err)
+	}
+
+	iter := people.Iter(bson.M{"age": bson.M{"$gte": 30}})
+	var names []string
+	for p, ok := iter.Next(); ok; p, ok = iter.Next() {
+		names = append(names, p.Name)
+	}
+	if err := iter.Close(); err != nil || len(names) != 2 {
+		t.Fatalf("iterated %v, %v", names, err)
+	}
+
+	ages, err := Distinct[typedPerson, int](people, "age", nil)
+	if err != nil || len(ages) != 2 {
+		t.Fatalf("got distinct ages %v, %v", ages, err)
+	}
+}
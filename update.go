@@ -0,0 +1,402 @@
+package mdb
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+// Update is an update document built with Set, Inc, Push and the other
+// operator functions, which may be passed as the update of Update,
+// UpdateId, UpdateAll, Upsert, UpsertId, UpdateVersioned and Query.Apply.
+//
+// Operators are merged into a single document, and paths are checked as
+// they are added: an update modifying a path twice, or both a path and a
+// field within it, is rejected before reaching the server. Err returns the
+// first such conflict.
+//
+// Paths may use the positional operators $, $[] and $[<identifier>], the
+// latter filtered with ArrayFilters. For example:
+//
+//     u := mdb.Set("status", "shipped").
+//         Inc("items.$[item].shipped", 1).
+//         CurrentDate("updatedAt").
+//         ArrayFilters(mdb.Where("item.sku").In("a", "b"))
+//     err := c.UpdateId(id, u)
+//
+// Updates are values: adding operators returns a new Update and leaves the
+// original unchanged.
+type Update struct {
+	doc          bson.D
+	arrayFilters []interface{}
+	paths        []string
+	err          error
+}
+
+// Set starts an update which sets field to value.
+func Set(field string, value interface{}) Update { return Update{}.Set(field, value) }
+
+// SetOnInsert starts an update which sets field to value when upserting.
+func SetOnInsert(field string, value interface{}) Update { return Update{}.SetOnInsert(field, value) }
+
+// Unset starts an update which removes fields.
+func Unset(fields ...string) Update { return Update{}.Unset(fields...) }
+
+// Inc starts an update which increments field by n.
+func Inc(field string, n interface{}) Update { return Update{}.Inc(field, n) }
+
+// Mul starts an update which multiplies field by n.
+func Mul(field string, n interface{}) Update { return Update{}.Mul(field, n) }
+
+// Min starts an update which sets field to value if value is lower.
+func Min(field string, value interface{}) Update { return Update{}.Min(field, value) }
+
+// Max starts an update which sets field to value if value is greater.
+func Max(field string, value interface{}) Update { return Update{}.Max(field, value) }
+
+// CurrentDate starts an update which sets fields to the server time.
+func CurrentDate(fields ...string) Update { return Update{}.CurrentDate(fields...) }
+
+// Rename starts an update which renames field from to field to.
+func Rename(from, to string) Update { return Update{}.Rename(from, to) }
+
+// Push starts an update which appends values to the array field.
+func Push(field string, values ...interface{}) Update { return Update{}.Push(field, values...) }
+
+// AddToSet starts an update which adds values missing from the array field.
+func AddToSet(field string, values ...interface{}) Update { return Update{}.AddToSet(field, values...) }
+
+// Pull starts an update which removes the elements of the array field
+// matching cond, a value or a Filter on the elements.
+func Pull(field string, cond interface{}) Update { return Update{}.Pull(field, cond) }
+
+// PullAll starts an update which removes values from the array field.
+func PullAll(field string, values ...interface{}) Update { return Update{}.PullAll(field, values...) }
+
+// Pop starts an update which removes the last element of the array field,
+// or the first one if first is set.
+func Pop(field string, first bool) Update { return Update{}.Pop(field, first) }
+
+// Set sets field to value.
+func (u Update) Set(field string, value interface{}) Update { return u.op("$set", field, value) }
+
+// SetOnInsert sets field to value when the update inserts a document.
+func (u Update) SetOnInsert(field string, value interface{}) Update {
+	return u.op("$setOnInsert", field, value)
+}
+
+// Unset removes fields.
+func (u Update) Unset(fields ...string) Update {
+	for _, field := range fields {
+		u = u.op("$unset", field, "")
+	}
+	return u
+}
+
+// Inc increments field by n.
+func (u Update) Inc(field string, n interface{}) Update { return u.op("$inc", field, n) }
+
+// Mul multiplies field by n.
+func (u Update) Mul(field string, n interface{}) Update { return u.op("$mul", field, n) }
+
+// Min sets field to value if value is lower than the current one.
+func (u Update) Min(field string, value interface{}) Update { return u.op("$min", field, value) }
+
+// Max sets field to value if value is greater than the current one.
+func (u Update) Max(field string, value interface{}) Update { return u.op("$max", field, value) }
+
+// CurrentDate sets fields to the current date of the server.
+func (u Update) CurrentDate(fields ...string) Update {
+	for _, field := range fields {
+		u = u.op("$currentDate", field, true)
+	}
+	return u
+}
+
+// Rename renames field from to field to.
+func (u Update) Rename(from, to string) Update {
+	if u.err != nil {
+		return u
+	}
+	if u.err = u.claim("$rename", to); u.err != nil {
+		return u
+	}
+	u.paths = append(u.paths[:len(u.paths):len(u.paths)], to)
+	return u.op("$rename", from, to)
+}
+
+// Push appends values to the array field. Values pushed to the same field
+// by several calls are appended in order.
+func (u Update) Push(field string, values ...interface{}) Update {
+	return u.each("$push", field, values)
+}
+
+// AddToSet adds to the array field the values it does not hold yet.
+func (u Update) AddToSet(field string, values ...interface{}) Update {
+	return u.each("$addToSet", field, values)
+}
+
+// Pull removes the elements of the array field matching cond, which may be
+// a value or a Filter on the elements.
+func (u Update) Pull(field string, cond interface{}) Update {
+	if f, ok := cond.(Filter); ok {
+		if u.err == nil {
+			u.err = f.Err()
+		}
+		cond = f.D()
+	}
+	return u.op("$pull", field, cond)
+}
+
+// PullAll removes every occurrence of values from the array field.
+func (u Update) PullAll(field string, values ...interface{}) Update {
+	return u.op("$pullAll", field, valueList(values))
+}
+
+// Pop removes the last element of the array field, or the first one if
+// first is set.
+func (u Update) Pop(field string, first bool) Update {
+	if first {
+		return u.op("$pop", field, -1)
+	}
+	return u.op("$pop", field, 1)
+}
+
+// ArrayFilters adds the filters selecting the array elements updated
+// through $[<identifier>] paths. Each filter may be a Filter or a document,
+// and names fields from the identifier, as in Where("item.qty").Gt(0).
+func (u Update) ArrayFilters(filters ...interface{}) Update {
+	list := append([]interface{}(nil), u.arrayFilters...)
+	for _, f := range filters {
+		if filter, ok := f.(Filter); ok {
+			if u.err == nil {
+				u.err = filter.Err()
+			}
+			f = filter.D()
+		}
+		list = append(list, f)
+	}
+	u.arrayFilters = list
+	return u
+}
+
+// D returns the update document.
+func (u Update) D() bson.D {
+	if u.doc == nil {
+		return bson.D{}
+	}
+	return u.doc
+}
+
+// Err returns the first conflict found while building the update.
+func (u Update) Err() error {
+	return u.err
+}
+
+// GetBSON implements bson.Getter, so that an Update marshals as its
+// document, or fails with its conflict.
+func (u Update) GetBSON() (interface{}, error) {
+	return u.D(), u.err
+}
+
+// String returns the update document as Extended JSON.
+func (u Update) String() string {
+	var b strings.Builder
+	writeJSON(&b, u.D())
+	return b.String()
+}
+
+// each adds values to an array operator, using $each when pushing several
+// values or when the field was already pushed to.
+func (u Update) each(op, field string, values []interface{}) Update {
+	if u.err != nil {
+		return u
+	}
+	for i, e := range u.doc {
+		if e.Name != op {
+			continue
+		}
+		fields := e.Value.(bson.D)
+		for j, f := range fields {
+			if f.Name != field {
+				continue
+			}
+			previous := []interface{}{f.Value}
+			if d, ok := f.Value.(bson.D); ok && len(d) == 1 && d[0].Name == "$each" {
+				previous = d[0].Value.([]interface{})
+			}
+			list := append(append([]interface{}(nil), previous...), values...)
+			fields = append(bson.D(nil), fields...)
+			fields[j].Value = bson.D{{Name: "$each", Value: list}}
+			u.doc = append(bson.D(nil), u.doc...)
+			u.doc[i].Value = fields
+			return u
+		}
+	}
+	if len(values) == 1 {
+		return u.op(op, field, values[0])
+	}
+	return u.op(op, field, bson.D{{Name: "$each", Value: valueList(values)}})
+}
+
+// op adds an operator on field, after checking it does not conflict with
+// the paths already updated.
+func (u Update) op(op, field string, value interface{}) Update {
+	if u.err != nil {
+		return u
+	}
+	if u.err = u.claim(op, field); u.err != nil {
+		return u
+	}
+	u.paths = append(u.paths[:len(u.paths):len(u.paths)], field)
+	doc := append(bson.D(nil), u.doc...)
+	for i, e := range doc {
+		if e.Name == op {
+			fields := append(bson.D(nil), e.Value.(bson.D)...)
+			doc[i].Value = append(fields, bson.DocElem{Name: field, Value: value})
+			u.doc = doc
+			return u
+		}
+	}
+	u.doc = append(doc, bson.DocElem{Name: op, Value: bson.D{{Name: field, Value: value}}})
+	return u
+}
+
+// claim returns an error if an operator on field would conflict with the
+// paths already updated.
+func (u Update) claim(op, field string) error {
+	if field == "" {
+		return fmt.Errorf("mdb: %s with an empty field name", op)
+	}
+	for _, path := range u.paths {
+		if path == field || strings.HasPrefix(field, path+".") || strings.HasPrefix(path, field+".") {
+			return fmt.Errorf("mdb: updating the path '%s' with %s would create a conflict at '%s'", field, op, path)
+		}
+	}
+	return nil
+}
+
+// splitUpdate returns the document and array filters of an update built
+// with the Update functions, or update itself.
+func splitUpdate(update interface{}) (doc interface{}, arrayFilters []interface{}, err error) {
+	switch u := update.(type) {
+	case Update:
+		return u.D(), u.arrayFilters, u.err
+	case *Update:
+		return u.D(), u.arrayFilters, u.err
+	}
+	return update, nil, nil
+}
+
+// runUpdate sends an update command with array filters, which mgo does not
+// support.
+func (c *Collection) runUpdate(selector, update interface{}, arrayFilters []interface{}, multi, upsert bool) (*mgo.ChangeInfo, error) {
+	if selector == nil {
+		selector = bson.D{}
+	}
+	cmd := bson.D{
+		{Name: "update", Value: c.Name},
+		{Name: "updates", Value: []interface{}{bson.D{
+			{Name: "q", Value: selector},
+			{Name: "u", Value: update},
+			{Name: "multi", Value: multi},
+			{Name: "upsert", Value: upsert},
+			{Name: "arrayFilters", Value: arrayFilters},
+		}}},
+	}
+	var result struct {
+		N         int `bson:"n"`
+		NModified int `bson:"nModified"`
+		Upserted  []struct {
+			Id interface{} `bson:"_id"`
+		} `bson:"upserted"`
+		WriteErrors []struct {
+			Code   int    `bson:"code"`
+			ErrMsg string `bson:"errmsg"`
+		} `bson:"writeErrors"`
+	}
+	if err := c.Database.session.DB(c.Database.Name).Run(cmd, &result); err != nil {
+		return nil, err
+	}
+	if len(result.WriteErrors) > 0 {
+		e := result.WriteErrors[0]
+		return nil, &mgo.LastError{Err: e.ErrMsg, Code: e.Code}
+	}
+	info := &mgo.ChangeInfo{Updated: result.NModified, Matched: result.N}
+	if len(result.Upserted) > 0 {
+		info.Matched--
+		info.UpsertedId = result.Upserted[0].Id
+	}
+	if info.Matched == 0 && info.UpsertedId == nil && !multi {
+		return info, mgo.ErrNotFound
+	}
+	return info, nil
+}
+
+// runFindAndModify sends a findAndModify command with array filters, which
+// mgo does not support.
+func (q *Query) runFindAndModify(change mgo.Change, arrayFilters []interface{}, result interface{}) (*mgo.ChangeInfo, error) {
+	filter := q.filter
+	if filter == nil {
+		filter = bson.D{}
+	}
+	cmd := bson.D{
+		{Name: "findAndModify", Value: q.col.Name},
+		{Name: "query", Value: filter},
+		{Name: "update", Value: change.Update},
+		{Name: "new", Value: change.ReturnNew},
+		{Name: "upsert", Value: change.Upsert},
+		{Name: "arrayFilters", Value: arrayFilters},
+	}
+	if len(q.sort) > 0 {
+		cmd = append(cmd, bson.DocElem{Name: "sort", Value: sortDoc(q.sort)})
+	}
+	if q.selector != nil {
+		cmd = append(cmd, bson.DocElem{Name: "fields", Value: q.selector})
+	}
+	var reply struct {
+		Value           bson.Raw `bson:"value"`
+		LastErrorObject struct {
+			N               int         `bson:"n"`
+			UpdatedExisting bool        `bson:"updatedExisting"`
+			Upserted        interface{} `bson:"upserted"`
+		} `bson:"lastErrorObject"`
+	}
+	if err := q.db.session.DB(q.db.Name).Run(cmd, &reply); err != nil {
+		return nil, err
+	}
+	last := reply.LastErrorObject
+	if last.N == 0 {
+		return nil, mgo.ErrNotFound
+	}
+	if result != nil && reply.Value.Kind == 0x03 {
+		if err := reply.Value.Unmarshal(result); err != nil {
+			return nil, err
+		}
+	}
+	info := &mgo.ChangeInfo{UpsertedId: last.Upserted}
+	if last.UpdatedExisting {
+		info.Matched, info.Updated = last.N, last.N
+	}
+	return info, nil
+}
+
+// sortDoc converts the fields of Query.Sort into a sort document.
+func sortDoc(fields []string) bson.D {
+	var d bson.D
+	for _, field := range fields {
+		order := 1
+		switch {
+		case strings.HasPrefix(field, "-"):
+			field, order = field[1:], -1
+		case strings.HasPrefix(field, "+"):
+			field = field[1:]
+		}
+		if field != "" {
+			d = append(d, bson.DocElem{Name: field, Value: order})
+		}
+	}
+	return d
+}
@@ -0,0 +1,109 @@
+package mdb
+
+import (
+	"testing"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+func TestUpdateDocuments(t *testing.T) {
+	tests := []struct {
+		update Update
+		want   string
+	}{
+		{Set("name", "Ale").Inc("n", 1).Set("age", 3), `{"$set":{"name":"Ale","age":3},"$inc":{"n":1}}`},
+		{Push("tags", "a").Push("tags", "b", "c"), `{"$push":{"tags":{"$each":["a","b","c"]}}}`},
+		{AddToSet("tags", "a", "b"), `{"$addToSet":{"tags":{"$each":["a","b"]}}}`},
+		{Unset("a", "b").CurrentDate("at"), `{"$unset":{"a":"","b":""},"$currentDate":{"at":true}}`},
+		{Pull("items", Where("qty").Lte(0)).Pop("queue", true), `{"$pull":{"items":{"qty":{"$lte":0}}},"$pop":{"queue":-1}}`},
+		{Min("low", 1).Max("high", 9).Mul("x", 2).Rename("old", "new"), `{"$min":{"low":1},"$max":{"high":9},"$mul":{"x":2},"$rename":{"old":"new"}}`},
+		{Update{}, `{}`},
+	}
+	for _, test := range tests {
+		if err := test.update.Err(); err != nil {
+			t.Errorf("%s: %v", test.want, err)
+		}
+		if got := test.update.String(); got != test.want {
+			t.Errorf("got %s, want %s", got, test.want)
+		}
+	}
+
+	conflicts := []Update{
+		Set("a", 1).Inc("a", 1),
+		Set("a.b", 1).Unset("a"),
+		Set("a", 1).Set("a.b", 2),
+		Rename("a", "b").Set("b.c", 1),
+		Push("tags", 1).Set("tags", nil),
+	}
+	for _, u := range conflicts {
+		if u.Err() == nil {
+			t.Errorf("conflict not detected in %s", u)
+		}
+	}
+	base := Set("a", 1)
+	base.Set("b", 2)
+	if got := base.String(); got != `{"$set":{"a":1}}` {
+		t.Fatalf("update modified by derived update: %s", got)
+	}
+}
+
+func TestUpdateBuilder(t *testing.T) {
+	_, db := dialTest(t)
+	c := db.C("orders")
+	order := bson.M{"_id": 1, "status": "new", "items": []bson.M{
+		{"sku": "a", "qty": 1}, {"sku": "b", "qty": 2}, {"sku": "c", "qty": 3},
+	}}
+	if err := c.Insert(order); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Update(bson.M{"_id": 1, "items.sku": "b"}, Set("items.$.qty", 20).Push("log", "b")); err != nil {
+		t.Fatal(err)
+	}
+	u := Set("status", "packed").Inc("items.$[item].qty", 100).ArrayFilters(Where("item.qty").Gte(3))
+	if err := c.UpdateId(1, u); err != nil {
+		t.Fatal(err)
+	}
+	var result struct {
+		Status string
+		Items  []struct {
+			Sku string
+			Qty int
+		}
+		Log []string
+	}
+	if err := c.FindId(1).One(&result); err != nil {
+		t.Fatal(err)
+	}
+	qty := []int{result.Items[0].Qty, result.Items[1].Qty, result.Items[2].Qty}
+	if result.Status != "packed" || qty[0] != 1 || qty[1] != 120 || qty[2] != 103 || len(result.Log) != 1 {
+		t.Fatalf("updated order: %+v", result)
+	}
+
+	if err := c.UpdateId(2, u); err != mgo.ErrNotFound {
+		t.Fatalf("update of missing document: %v", err)
+	}
+	info, err := c.UpdateAll(nil, Set("items.$[].qty", 0).ArrayFilters())
+	if err != nil || info.Matched != 1 {
+		t.Fatalf("update all: %+v %v", info, err)
+	}
+	info, err = c.UpsertId(2, SetOnInsert("status", "new").Set("items.$[i].qty", 1).ArrayFilters(bson.M{"i.sku": "a"}))
+	if err != nil || info.UpsertedId != 2 {
+		t.Fatalf("upsert: %+v %v", info, err)
+	}
+
+	change := mgo.Change{Update: Inc("items.$[item].qty", 5).ArrayFilters(Where("item.sku").Eq("c")), ReturnNew: true}
+	if _, err := c.FindId(1).Apply(change, &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Items[2].Qty != 5 || result.Items[0].Qty != 0 {
+		t.Fatalf("applied order: %+v", result)
+	}
+	if _, err := c.FindId(3).Apply(change, &result); err != mgo.ErrNotFound {
+		t.Fatalf("apply to missing document: %v", err)
+	}
+
+	if err := c.UpdateId(1, Set("a", 1).Unset("a")); err == nil {
+		t.Fatal("conflicting update sent")
+	}
+}
@@ -0,0 +1,312 @@
+package mdb
+
+import (
+	"fmt"
+	"net/mail"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// FieldError describes a field which failed a validation rule.
+type FieldError struct {
+	Path    string // Document path of the field, such as "address.city" or "tags.2".
+	Rule    string // Rule which failed, such as "min=3".
+	Message string
+}
+
+func (e FieldError) Error() string {
+	return e.Path + ": " + e.Message
+}
+
+// ValidationError is returned by ValidateStruct, and by the writes of a
+// collection with struct validation, for documents breaking the rules of
+// their `validate` tags.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		msgs[i] = f.Error()
+	}
+	return "mdb: invalid document: " + strings.Join(msgs, "; ")
+}
+
+// WithStructValidation returns a copy of the collection which validates
+// struct documents with ValidateStruct before Insert, and replacement
+// documents before Update, UpdateId, Upsert, UpsertId and UpdateVersioned,
+// so that invalid documents never reach the server. Documents which are not
+// structs, such as bson.M, are not validated.
+func (c *Collection) WithStructValidation() *Collection {
+	cc := *c
+	cc.validateStructs = true
+	return &cc
+}
+
+// validate validates docs if the collection has struct validation.
+func (c *Collection) validate(docs ...interface{}) error {
+	if !c.validateStructs {
+		return nil
+	}
+	for _, doc := range docs {
+		if err := ValidateStruct(doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidateStruct checks the fields of doc, a struct or a pointer to one,
+// against their `validate` tags, descending into nested structs and slices
+// of structs. Fields are named by their document paths, taken from the bson
+// tags. A *ValidationError lists every failed field; other errors report
+// malformed tags. Values which are not structs are valid.
+//
+// The tag holds a comma separated list of rules:
+//
+//     required     the field is not the zero value, nor an empty slice or map
+//     omitempty    skip the other rules when the field is the zero value
+//     min=<n>      numbers are at least n; strings, slices and maps have
+//                  at least n elements
+//     max=<n>      numbers are at most n; strings, slices and maps have at
+//                  most n elements
+//     len=<n>      numbers are n; strings, slices and maps have n elements
+//     oneof=<a b>  the value is one of the space separated values
+//     email        the string is an email address
+//     regex=<re>   the string matches re; as re may hold commas, it must be
+//                  the last rule
+//
+// For example:
+//
+//     type Person struct {
+//         Name  string   `bson:"name" validate:"required,max=64"`
+//         Email string   `bson:"email" validate:"omitempty,email"`
+//         Role  string   `bson:"role" validate:"oneof=admin user"`
+//         Tags  []string `bson:"tags" validate:"max=8"`
+//     }
+//
+func ValidateStruct(doc interface{}) error {
+	v := reflect.ValueOf(doc)
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	var fields []FieldError
+	if err := validateValue(v, "", &fields); err != nil {
+		return err
+	}
+	if len(fields) > 0 {
+		return &ValidationError{Fields: fields}
+	}
+	return nil
+}
+
+// validateValue validates the fields of struct values held by v.
+func validateValue(v reflect.Value, path string, fields *[]FieldError) error {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		if v.Type() == timeType {
+			return nil
+		}
+		return validateStruct(v, path, fields)
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := validateValue(v.Index(i), joinPath(path, strconv.Itoa(i)), fields); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func validateStruct(v reflect.Value, prefix string, fields *[]FieldError) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			continue
+		}
+		name, inline, skip := bsonFieldName(f)
+		if skip {
+			continue
+		}
+		path := joinPath(prefix, name)
+		if inline {
+			path = prefix
+		}
+		fv := v.Field(i)
+		if tag := f.Tag.Get("validate"); tag != "" {
+			if err := checkRules(fv, path, tag, fields); err != nil {
+				return fmt.Errorf("mdb: field %s.%s: %v", t.Name(), f.Name, err)
+			}
+		}
+		if err := validateValue(fv, path, fields); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func joinPath(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
+
+// checkRules checks the value of a field against the rules of its tag.
+func checkRules(v reflect.Value, path, tag string, fields *[]FieldError) error {
+	fail := func(rule, format string, args ...interface{}) {
+		*fields = append(*fields, FieldError{Path: path, Rule: rule, Message: fmt.Sprintf(format, args...)})
+	}
+	zero, missing := isEmptyValue(v), false
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			missing = true
+			break
+		}
+		v = v.Elem()
+	}
+	rules := strings.Split(tag, ",")
+	for i := 0; i < len(rules); i++ {
+		rule := rules[i]
+		key, arg := rule, ""
+		if j := strings.Index(rule, "="); j >= 0 {
+			key, arg = rule[:j], rule[j+1:]
+		}
+		if key == "regex" {
+			arg = strings.Join(append([]string{arg}, rules[i+1:]...), ",")
+			rule, i = "regex="+arg, len(rules)
+		}
+		if missing && key != "required" {
+			// Only required applies to nil pointers and interfaces.
+			continue
+		}
+		switch key {
+		case "required":
+			if zero {
+				fail(rule, "is required")
+				return nil
+			}
+		case "omitempty":
+			if zero {
+				return nil
+			}
+		case "min", "max", "len":
+			n, err := strconv.ParseFloat(arg, 64)
+			if err != nil {
+				return fmt.Errorf("invalid %s %q", key, arg)
+			}
+			size, isLen, ok := sizeOf(v)
+			if !ok {
+				return fmt.Errorf("%s does not apply to %s", key, v.Type())
+			}
+			what := "be"
+			if isLen {
+				what = "have a length of"
+			}
+			switch {
+			case key == "min" && size < n:
+				fail(rule, "must %s at least %s", what, arg)
+			case key == "max" && size > n:
+				fail(rule, "must %s at most %s", what, arg)
+			case key == "len" && size != n:
+				fail(rule, "must %s %s", what, arg)
+			}
+		case "oneof":
+			if !containsString(strings.Fields(arg), fmt.Sprint(v.Interface())) {
+				fail(rule, "must be one of %s", strings.Join(strings.Fields(arg), ", "))
+			}
+		case "email":
+			s, ok := stringValue(v)
+			if !ok {
+				return fmt.Errorf("email does not apply to %s", v.Type())
+			}
+			if addr, err := mail.ParseAddress(s); err != nil || addr.Address != s {
+				fail(rule, "must be an email address")
+			}
+		case "regex":
+			re, err := compileRule(arg)
+			if err != nil {
+				return err
+			}
+			s, ok := stringValue(v)
+			if !ok {
+				return fmt.Errorf("regex does not apply to %s", v.Type())
+			}
+			if !re.MatchString(s) {
+				fail(rule, "must match %s", arg)
+			}
+		case "":
+		default:
+			return fmt.Errorf("unknown validation rule %q", key)
+		}
+	}
+	return nil
+}
+
+// isEmptyValue reports whether v is the zero value, or an empty slice or map.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	}
+	return v.IsZero()
+}
+
+// sizeOf returns the value of a number, or the length of a string, slice or
+// map, with isLen set.
+func sizeOf(v reflect.Value) (size float64, isLen, ok bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), false, true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), false, true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), false, true
+	case reflect.String:
+		return float64(utf8.RuneCountInString(v.String())), true, true
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return float64(v.Len()), true, true
+	}
+	return 0, false, false
+}
+
+func stringValue(v reflect.Value) (string, bool) {
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), true
+	}
+	return "", false
+}
+
+var ruleRegexps sync.Map
+
+// compileRule compiles the expression of a regex rule, caching it.
+func compileRule(expr string) (*regexp.Regexp, error) {
+	if re, ok := ruleRegexps.Load(expr); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex %q: %v", expr, err)
+	}
+	ruleRegexps.Store(expr, re)
+	return re, nil
+}
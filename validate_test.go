@@ -0,0 +1,110 @@
+package mdb
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+type validAddress struct {
+	City string `bson:"city" validate:"required"`
+	Zip  string `bson:"zip" validate:"omitempty,len=5,regex=^[0-9]+$"`
+}
+
+type validPerson struct {
+	Id        int            `bson:"_id"`
+	Name      string         `bson:"name" validate:"required,min=2,max=8"`
+	Age       int            `bson:"age" validate:"min=18,max=130"`
+	Email     string         `bson:"email" validate:"omitempty,email"`
+	Role      string         `bson:"role" validate:"oneof=admin user"`
+	Nick      *string        `bson:"nick" validate:"min=2"`
+	Address   *validAddress  `bson:"address" validate:"required"`
+	Addresses []validAddress `bson:"addresses" validate:"max=2"`
+}
+
+func validPersonDoc() validPerson {
+	return validPerson{
+		Id:      1,
+		Name:    "Ale",
+		Age:     30,
+		Role:    "user",
+		Address: &validAddress{City: "Rome", Zip: "00100"},
+	}
+}
+
+func TestValidateStruct(t *testing.T) {
+	p := validPersonDoc()
+	if err := ValidateStruct(&p); err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateStruct(bson.M{"name": ""}); err != nil {
+		t.Fatalf("map was validated: %v", err)
+	}
+
+	p.Name = "A"
+	p.Age = 12
+	p.Email = "not an address"
+	p.Role = "root"
+	p.Address.Zip = "0010a"
+	p.Addresses = []validAddress{{City: "Rome"}, {}}
+	err := ValidateStruct(p)
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("error %#v", err)
+	}
+	var got []string
+	for _, f := range verr.Fields {
+		got = append(got, f.Path+" "+f.Rule)
+	}
+	want := []string{
+		"name min=2",
+		"age min=18",
+		"email email",
+		"role oneof=admin user",
+		"address.zip regex=^[0-9]+$",
+		"addresses.1.city required",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("failed fields:\n%q\nwant:\n%q", got, want)
+	}
+
+	p = validPersonDoc()
+	p.Address = nil
+	if err := ValidateStruct(p); err == nil || err.Error() != "mdb: invalid document: address: is required" {
+		t.Fatalf("nil pointer: %v", err)
+	}
+
+	var bad struct {
+		Name string `validate:"maximum=3"`
+	}
+	if _, ok := ValidateStruct(bad).(*ValidationError); ok {
+		t.Fatal("unknown rule reported as a validation error")
+	}
+}
+
+func TestStructValidation(t *testing.T) {
+	_, db := dialTest(t)
+	c := db.C("people").WithStructValidation()
+	invalid := validPersonDoc()
+	invalid.Age = 3
+	if _, ok := c.Insert(&invalid).(*ValidationError); !ok {
+		t.Fatal("invalid document inserted")
+	}
+	if n, _ := c.Count(); n != 0 {
+		t.Fatalf("%d documents reached the server", n)
+	}
+	valid := validPersonDoc()
+	if err := c.Insert(&valid); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.UpdateId(1, invalid).(*ValidationError); !ok {
+		t.Fatal("invalid replacement accepted")
+	}
+	if _, err := c.UpsertId(1, &invalid); err == nil {
+		t.Fatal("invalid upsert accepted")
+	}
+	if err := c.UpdateId(1, bson.M{"$set": bson.M{"age": 3}}); err != nil {
+		t.Fatalf("operator update: %v", err)
+	}
+}
@@ -0,0 +1,277 @@
+package mdb
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+// Validation holds the document validation settings of a collection.
+type Validation struct {
+	Validator bson.M // Validator expression, such as {"$jsonSchema": schema}.
+	Level     string // validationLevel: "strict" by default, "moderate" or "off".
+	Action    string // validationAction: "error" by default, or "warn".
+}
+
+// SetValidation sets the validator of the collection with collMod, or
+// creates the collection with it if it does not exist yet. A nil Validator
+// removes the current one.
+//
+// For example, to validate documents against the schema of a struct:
+//
+//     schema, err := mdb.JSONSchemaFor(Person{})
+//     err = c.SetValidation(mdb.Validation{Validator: bson.M{"$jsonSchema": schema}})
+//
+// Relevant documentation:
+//
+//     https://docs.mongodb.com/manual/core/schema-validation/
+//
+func (c *Collection) SetValidation(v Validation) error {
+	validator := v.Validator
+	if validator == nil {
+		validator = bson.M{}
+	}
+	cmd := bson.D{{Name: "collMod", Value: c.Name}, {Name: "validator", Value: validator}}
+	if v.Level != "" {
+		cmd = append(cmd, bson.DocElem{Name: "validationLevel", Value: v.Level})
+	}
+	if v.Action != "" {
+		cmd = append(cmd, bson.DocElem{Name: "validationAction", Value: v.Action})
+	}
+	err := c.Database.Run(cmd, nil)
+	if err == nil || !isNamespaceNotFound(err) {
+		return err
+	}
+	return c.Create(&mgo.CollectionInfo{
+		Validator:        validator,
+		ValidationLevel:  v.Level,
+		ValidationAction: v.Action,
+	})
+}
+
+// Validation returns the validation settings of the collection, read with
+// listCollections, or mgo.ErrNotFound if the collection does not exist.
+func (c *Collection) Validation() (*Validation, error) {
+	var result struct {
+		Cursor struct {
+			FirstBatch []struct {
+				Options struct {
+					Validator        bson.M `bson:"validator"`
+					ValidationLevel  string `bson:"validationLevel"`
+					ValidationAction string `bson:"validationAction"`
+				} `bson:"options"`
+			} `bson:"firstBatch"`
+		} `bson:"cursor"`
+	}
+	cmd := bson.D{{Name: "listCollections", Value: 1}, {Name: "filter", Value: bson.D{{Name: "name", Value: c.Name}}}}
+	if err := c.Database.Run(cmd, &result); err != nil {
+		return nil, err
+	}
+	if len(result.Cursor.FirstBatch) == 0 {
+		return nil, mgo.ErrNotFound
+	}
+	options := result.Cursor.FirstBatch[0].Options
+	return &Validation{
+		Validator: options.Validator,
+		Level:     options.ValidationLevel,
+		Action:    options.ValidationAction,
+	}, nil
+}
+
+// Diff lists the differences from v to desired, one per changed value, as
+// in "validator.$jsonSchema.required: [name] -> [name age]". Unset levels
+// and actions are compared as their defaults.
+func (v *Validation) Diff(desired *Validation) []string {
+	var diff []string
+	level := func(v *Validation) string { return defaultString(v.Level, "strict") }
+	action := func(v *Validation) string { return defaultString(v.Action, "error") }
+	if level(v) != level(desired) {
+		diff = append(diff, fmt.Sprintf("validationLevel: %s -> %s", level(v), level(desired)))
+	}
+	if action(v) != action(desired) {
+		diff = append(diff, fmt.Sprintf("validationAction: %s -> %s", action(v), action(desired)))
+	}
+	from, to := normalizeValue(v.Validator), normalizeValue(desired.Validator)
+	if from == nil {
+		from = bson.M{}
+	}
+	if to == nil {
+		to = bson.M{}
+	}
+	return diffValues("validator", from, to, diff)
+}
+
+func defaultString(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
+
+// normalizeValue returns v after a bson round trip, so that values written
+// in Go compare equal to the ones read from the server.
+func normalizeValue(v interface{}) interface{} {
+	data, err := bson.Marshal(bson.M{"v": v})
+	if err != nil {
+		return v
+	}
+	var out bson.M
+	if err := bson.Unmarshal(data, &out); err != nil {
+		return v
+	}
+	return out["v"]
+}
+
+// diffValues appends to diff the differences between two normalized values,
+// descending into documents.
+func diffValues(path string, from, to interface{}, diff []string) []string {
+	fromM, ok1 := from.(bson.M)
+	toM, ok2 := to.(bson.M)
+	if !ok1 || !ok2 {
+		if !reflect.DeepEqual(from, to) {
+			diff = append(diff, fmt.Sprintf("%s: %s -> %s", path, showValue(from), showValue(to)))
+		}
+		return diff
+	}
+	keys := make([]string, 0, len(fromM)+len(toM))
+	for k := range fromM {
+		keys = append(keys, k)
+	}
+	for k := range toM {
+		if _, ok := fromM[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		a, inFrom := fromM[k]
+		b, inTo := toM[k]
+		switch {
+		case !inFrom:
+			diff = append(diff, fmt.Sprintf("%s.%s: <none> -> %s", path, k, showValue(b)))
+		case !inTo:
+			diff = append(diff, fmt.Sprintf("%s.%s: %s -> <none>", path, k, showValue(a)))
+		default:
+			diff = diffValues(path+"."+k, a, b, diff)
+		}
+	}
+	return diff
+}
+
+func showValue(v interface{}) string {
+	if data, err := bson.MarshalJSON(v); err == nil {
+		return strings.TrimSpace(string(data))
+	}
+	return fmt.Sprint(v)
+}
+
+// JSONSchemaFor derives a $jsonSchema document from model, which must be a
+// struct or a pointer to one. Field names are taken from the bson tags and
+// bsonType from the Go types; fields without omitempty are required, as
+// bson always writes them, and pointer fields may also be null.
+//
+// For example, the schema of:
+//
+//     type Person struct {
+//         Id   bson.ObjectId `bson:"_id"`
+//         Name string        `bson:"name"`
+//         Age  int           `bson:"age,omitempty"`
+//     }
+//
+// requires _id and name, of bsonType objectId and string, and allows an
+// optional age of bsonType int or long.
+func JSONSchemaFor(model interface{}) (bson.M, error) {
+	t := reflect.TypeOf(model)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("mdb: JSONSchemaFor needs a struct, got %T", model)
+	}
+	return schemaFor(t, map[reflect.Type]bool{}), nil
+}
+
+var (
+	objectIdType = reflect.TypeOf(bson.ObjectId(""))
+	bytesType    = reflect.TypeOf([]byte(nil))
+)
+
+func schemaFor(t reflect.Type, seen map[reflect.Type]bool) bson.M {
+	switch t {
+	case timeType:
+		return bson.M{"bsonType": "date"}
+	case objectIdType:
+		return bson.M{"bsonType": "objectId"}
+	case bytesType:
+		return bson.M{"bsonType": "binData"}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		schema := schemaFor(t.Elem(), seen)
+		switch bsonType := schema["bsonType"].(type) {
+		case string:
+			schema["bsonType"] = []string{bsonType, "null"}
+		case []string:
+			schema["bsonType"] = append(bsonType, "null")
+		}
+		return schema
+	case reflect.Bool:
+		return bson.M{"bsonType": "bool"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16:
+		return bson.M{"bsonType": "int"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return bson.M{"bsonType": []string{"int", "long"}}
+	case reflect.Float32, reflect.Float64:
+		return bson.M{"bsonType": "double"}
+	case reflect.String:
+		return bson.M{"bsonType": "string"}
+	case reflect.Slice, reflect.Array:
+		return bson.M{"bsonType": "array", "items": schemaFor(t.Elem(), seen)}
+	case reflect.Map:
+		return bson.M{"bsonType": "object"}
+	case reflect.Struct:
+		if seen[t] {
+			return bson.M{"bsonType": "object"}
+		}
+		seen[t] = true
+		defer delete(seen, t)
+		properties := bson.M{}
+		var required []string
+		addProperties(t, properties, &required, seen)
+		schema := bson.M{"bsonType": "object", "properties": properties}
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+		return schema
+	}
+	return bson.M{}
+}
+
+// addProperties adds the fields of struct t, including inlined ones, to a
+// schema.
+func addProperties(t reflect.Type, properties bson.M, required *[]string, seen map[reflect.Type]bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			continue
+		}
+		name, inline, skip := bsonFieldName(f)
+		if skip {
+			continue
+		}
+		if inline {
+			if f.Type.Kind() == reflect.Struct {
+				addProperties(f.Type, properties, required, seen)
+			}
+			continue
+		}
+		properties[name] = schemaFor(f.Type, seen)
+		if !containsString(strings.Split(bsonTag(f), ",")[1:], "omitempty") {
+			*required = append(*required, name)
+		}
+	}
+}
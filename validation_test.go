@@ -0,0 +1,106 @@
+package mdb
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+type schemaAddress struct {
+	City string `bson:"city"`
+}
+
+type schemaPerson struct {
+	Id      bson.ObjectId  `bson:"_id"`
+	Name    string         `bson:"name"`
+	Age     int            `bson:"age,omitempty"`
+	Born    time.Time      `bson:"born"`
+	Tags    []string       `bson:"tags"`
+	Address *schemaAddress `bson:"address"`
+	Secret  string         `bson:"-"`
+}
+
+func TestJSONSchemaFor(t *testing.T) {
+	schema, err := JSONSchemaFor(&schemaPerson{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := bson.M{
+		"bsonType": "object",
+		"properties": bson.M{
+			"_id":  bson.M{"bsonType": "objectId"},
+			"name": bson.M{"bsonType": "string"},
+			"age":  bson.M{"bsonType": []string{"int", "long"}},
+			"born": bson.M{"bsonType": "date"},
+			"tags": bson.M{"bsonType": "array", "items": bson.M{"bsonType": "string"}},
+			"address": bson.M{
+				"bsonType":   []string{"object", "null"},
+				"properties": bson.M{"city": bson.M{"bsonType": "string"}},
+				"required":   []string{"city"},
+			},
+		},
+		"required": []string{"_id", "name", "born", "tags", "address"},
+	}
+	if !reflect.DeepEqual(schema, want) {
+		t.Fatalf("schema:\n%#v\nwant:\n%#v", schema, want)
+	}
+	if _, err := JSONSchemaFor(1); err == nil {
+		t.Fatal("schema of a non struct")
+	}
+}
+
+func TestValidation(t *testing.T) {
+	_, db := dialTest(t)
+	c := db.C("people")
+	if _, err := c.Validation(); err != mgo.ErrNotFound {
+		t.Fatalf("validation of missing collection: %v", err)
+	}
+	schema, err := JSONSchemaFor(schemaAddress{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	desired := &Validation{Validator: bson.M{"$jsonSchema": schema}, Action: "warn"}
+	if err := c.SetValidation(*desired); err != nil {
+		t.Fatal(err)
+	}
+	current, err := c.Validation()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := current.Diff(desired); len(diff) > 0 {
+		t.Fatalf("created validation differs: %v", diff)
+	}
+
+	schema["required"] = []string{"city", "zip"}
+	desired.Level = "moderate"
+	diff := current.Diff(desired)
+	want := []string{
+		"validationLevel: strict -> moderate",
+		`validator.$jsonSchema.required: ["city"] -> ["city","zip"]`,
+	}
+	if !reflect.DeepEqual(diff, want) {
+		t.Fatalf("diff:\n%q\nwant:\n%q", diff, want)
+	}
+	if err := c.SetValidation(*desired); err != nil {
+		t.Fatal(err)
+	}
+	if current, err = c.Validation(); err != nil {
+		t.Fatal(err)
+	}
+	if diff := current.Diff(desired); len(diff) > 0 {
+		t.Fatalf("modified validation differs: %v", diff)
+	}
+
+	if err := c.SetValidation(Validation{}); err != nil {
+		t.Fatal(err)
+	}
+	if current, err = c.Validation(); err != nil {
+		t.Fatal(err)
+	}
+	if len(current.Validator) != 0 {
+		t.Fatalf("validator not removed: %v", current.Validator)
+	}
+}
@@ -0,0 +1,134 @@
+package mdb
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+// ErrVersionConflict is matched, using errors.Is, by the errors returned by
+// UpdateVersioned when the document was modified since it was read.
+var ErrVersionConflict = errors.New("mdb: version conflict")
+
+// VersionConflictError is returned by UpdateVersioned when the document
+// exists but its version is no longer the expected one.
+type VersionConflictError struct {
+	Collection string
+	Id         interface{}
+	Expected   int
+}
+
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("mdb: version conflict on %s %v: expected version %d", e.Collection, e.Id, e.Expected)
+}
+
+// Is reports whether target is ErrVersionConflict.
+func (e *VersionConflictError) Is(target error) bool {
+	return target == ErrVersionConflict
+}
+
+// conflictRetries is the number of times RetryOnConflict runs its function.
+const conflictRetries = 10
+
+// WithVersionField returns a copy of the collection whose UpdateVersioned
+// stores document versions in field rather than in "version".
+func (c *Collection) WithVersionField(field string) *Collection {
+	cc := *c
+	cc.versionField = field
+	return &cc
+}
+
+func (c *Collection) versionKey() string {
+	if c.versionField == "" {
+		return "version"
+	}
+	return c.versionField
+}
+
+// UpdateVersioned applies update to the document with the given _id only if
+// its version is expectedVersion, and increments the version in the same
+// write. Documents without a version are at version 0.
+//
+// The update may use operators, which must not touch the version, or be a
+// replacement document, whose version is overwritten. When the document
+// exists with another version, a *VersionConflictError is returned; when it
+// does not exist, mgo.ErrNotFound is. An update sent again after a network
+// error, which finds the document at the next version, is taken as applied
+// by the attempt whose reply was lost.
+//
+// For example:
+//
+//     err := c.UpdateVersioned(id, acc.Version, bson.M{"$inc": bson.M{"balance": -10}})
+//     if errors.Is(err, mdb.ErrVersionConflict) {
+//         // reload acc and try again
+//     }
+//
+func (c *Collection) UpdateVersioned(id interface{}, expectedVersion int, update interface{}) error {
+	update, arrayFilters, err := splitUpdate(update)
+	if err != nil {
+		return err
+	}
+	if err := beforeUpdate(update); err != nil {
+		return err
+	}
+	if err := c.validate(update); err != nil {
+		return err
+	}
+	field := c.versionKey()
+	d, err := toDoc(update)
+	if err != nil {
+		return err
+	}
+	if isOperatorDoc(d) {
+		if updateTouches(d, field) {
+			return fmt.Errorf("mdb: UpdateVersioned update must not modify %q", field)
+		}
+		if d, err = addToOperator(d, "$inc", field, 1); err != nil {
+			return err
+		}
+	} else {
+		d = setDocField(d, field, expectedVersion+1)
+	}
+	var version interface{} = expectedVersion
+	if expectedVersion == 0 {
+		version = bson.D{{Name: "$in", Value: []interface{}{0, nil}}}
+	}
+	retried, err := c.update(bson.D{{Name: "_id", Value: id}, {Name: field, Value: version}}, Update{doc: d, arrayFilters: arrayFilters})
+	if err != mgo.ErrNotFound {
+		return err
+	}
+	var stored bson.M
+	if err := c.Find(bson.D{{Name: "_id", Value: id}}).Select(bson.D{{Name: field, Value: 1}}).One(&stored); err != nil {
+		return err
+	}
+	if retried && refKey(stored[field]) == refKey(expectedVersion+1) {
+		// The attempt interrupted by a network error was applied.
+		return nil
+	}
+	return &VersionConflictError{Collection: c.Name, Id: id, Expected: expectedVersion}
+}
+
+// RetryOnConflict calls fn until it returns an error other than a version
+// conflict, up to 10 times, and returns its last error. fn should reload the
+// document on every call, so that its changes apply to the latest version.
+//
+// For example:
+//
+//     err := mdb.RetryOnConflict(func() error {
+//         var acc Account
+//         if err := c.FindId(id).One(&acc); err != nil {
+//             return err
+//         }
+//         return c.UpdateVersioned(id, acc.Version, bson.M{"$set": bson.M{"balance": acc.Balance - 10}})
+//     })
+//
+func RetryOnConflict(fn func() error) (err error) {
+	for i := 0; i < conflictRetries; i++ {
+		if err = fn(); !errors.Is(err, ErrVersionConflict) {
+			return
+		}
+	}
+	return err
+}
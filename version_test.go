@@ -0,0 +1,102 @@
+package mdb
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+type account struct {
+	Id      int `bson:"_id"`
+	Balance int `bson:"balance"`
+	Version int `bson:"version"`
+}
+
+func TestUpdateVersioned(t *testing.T) {
+	srv, db := dialTest(t)
+	c := db.C("accounts")
+	if err := c.Insert(bson.M{"_id": 1, "balance": 100}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.UpdateVersioned(1, 0, bson.M{"$inc": bson.M{"balance": -10}}); err != nil {
+		t.Fatal(err)
+	}
+	err := c.UpdateVersioned(1, 0, bson.M{"$inc": bson.M{"balance": -10}})
+	if !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("stale update: %v", err)
+	}
+	if e, ok := err.(*VersionConflictError); !ok || e.Id != 1 || e.Expected != 0 {
+		t.Fatalf("conflict error: %#v", err)
+	}
+	if err := c.UpdateVersioned(1, 1, bson.D{{Name: "$inc", Value: bson.M{"balance": -10}}}); err != nil {
+		t.Fatal(err)
+	}
+	var acc account
+	if err := c.FindId(1).One(&acc); err != nil || acc.Balance != 80 || acc.Version != 2 {
+		t.Fatalf("operator as bson.M in bson.D: %+v %v", acc, err)
+	}
+	if err := c.UpdateVersioned(1, 2, &account{Id: 1, Balance: 50}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.FindId(1).One(&acc); err != nil || acc.Balance != 50 || acc.Version != 3 {
+		t.Fatalf("replacement: %+v %v", acc, err)
+	}
+	if err := c.UpdateVersioned(2, 0, bson.M{"$set": bson.M{"balance": 1}}); err != mgo.ErrNotFound {
+		t.Fatalf("missing document: %v", err)
+	}
+	if err := c.UpdateVersioned(1, 2, bson.M{"$set": bson.M{"version": 7}}); err == nil {
+		t.Fatal("update touching the version was accepted")
+	}
+	if err := c.UpdateVersioned(1, 3, bson.D{{Name: "$set", Value: bson.M{"version": 7}}}); err == nil {
+		t.Fatal("update touching the version in a nested bson.M was accepted")
+	}
+
+	srv.DropAfterNext("update", 1)
+	if err := c.UpdateVersioned(1, 3, bson.M{"$inc": bson.M{"balance": -10}}); err != nil {
+		t.Fatalf("update applied before the connection broke: %v", err)
+	}
+	if err := c.FindId(1).One(&acc); err != nil || acc.Balance != 40 || acc.Version != 4 {
+		t.Fatalf("after a lost reply: %+v %v", acc, err)
+	}
+}
+
+func TestRetryOnConflict(t *testing.T) {
+	_, db := dialTest(t)
+	c := db.C("accounts")
+	if err := c.Insert(&account{Id: 1, Balance: 100}); err != nil {
+		t.Fatal(err)
+	}
+	calls := 0
+	err := RetryOnConflict(func() error {
+		calls++
+		var acc account
+		if err := c.FindId(1).One(&acc); err != nil {
+			return err
+		}
+		if calls == 1 {
+			// A concurrent worker updates the account after it was read.
+			if err := c.UpdateVersioned(1, acc.Version, bson.M{"$inc": bson.M{"balance": 5}}); err != nil {
+				return err
+			}
+		}
+		return c.UpdateVersioned(1, acc.Version, bson.M{"$set": bson.M{"balance": acc.Balance - 10}})
+	})
+	if err != nil || calls != 2 {
+		t.Fatalf("calls %d: %v", calls, err)
+	}
+	var acc account
+	if err := c.FindId(1).One(&acc); err != nil || acc.Balance != 95 || acc.Version != 2 {
+		t.Fatalf("lost update: %+v %v", acc, err)
+	}
+
+	calls = 0
+	err = RetryOnConflict(func() error {
+		calls++
+		return &VersionConflictError{Collection: "accounts", Id: 1}
+	})
+	if !errors.Is(err, ErrVersionConflict) || calls != conflictRetries {
+		t.Fatalf("calls %d: %v", calls, err)
+	}
+}